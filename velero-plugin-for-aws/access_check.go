@@ -0,0 +1,144 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	prefixKey         = "prefix"
+	validateOnInitKey = "validateOnInit"
+)
+
+// accessCheckKeyPrefix is the name prefix of the probe object written by
+// checkAccess. It's a file rather than a directory so that Velero's own
+// check of the top-level layout under the prefix ignores it.
+const accessCheckKeyPrefix = ".velero-access-check-"
+
+// checkAccess verifies that the bucket is reachable and that the location's
+// credentials can list, write, read and delete objects under prefix. The
+// write goes through PutObject so it uses the configured server-side
+// encryption, which also verifies that any KMS key can be used. A read-only
+// location is only checked for listing. All failed checks are reported in a
+// single error.
+func (o *ObjectStore) checkAccess(bucket, prefix string) (err error) {
+	ctx, span := startSpan("ObjectStore.checkAccess", bucketAttribute.String(bucket), keyAttribute.String(prefix))
+	defer func() { endSpan(span, err) }()
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"prefix": prefix,
+	})
+
+	var failures []string
+	fail := func(check string, err error) {
+		log.WithError(err).Warnf("Access check %q failed", check)
+		failures = append(failures, fmt.Sprintf("%s: %v", check, err))
+	}
+
+	if _, err := o.s3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		// nothing else can succeed if the bucket can't be reached
+		fail("head bucket", err)
+		return accessCheckError(failures)
+	}
+
+	listReq := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	}
+	if err := o.s3.ListObjectsV2PagesWithContext(ctx, listReq, func(*s3.ListObjectsV2Output, bool) bool { return false }); err != nil {
+		fail("list objects", err)
+	}
+
+	// a read-only location must not be written to, even by the probe
+	if o.readOnly {
+		if len(failures) > 0 {
+			return accessCheckError(failures)
+		}
+		log.Info("Access check passed (read-only, writes not checked)")
+		return nil
+	}
+
+	key := path.Join(prefix, fmt.Sprintf("%s%d", accessCheckKeyPrefix, time.Now().UnixNano()))
+	content := []byte(key)
+
+	putCheck := "put object"
+	switch {
+	case o.kmsKeyID != "":
+		putCheck = fmt.Sprintf("put object (SSE-KMS key %s)", o.kmsKeyID)
+	case o.serverSideEncryption != "":
+		putCheck = fmt.Sprintf("put object (SSE %s)", o.serverSideEncryption)
+	}
+
+	if err := o.PutObject(bucket, key, bytes.NewReader(content)); err != nil {
+		fail(putCheck, err)
+		failures = append(failures, "get object, delete object: not checked because the probe object could not be written")
+		return accessCheckError(failures)
+	}
+
+	if err := o.checkProbeObject(bucket, key, content); err != nil {
+		fail("get object", err)
+	}
+
+	// the probe object is removed outright even if soft delete is enabled
+	if err := o.removeObject(ctx, bucket, key); err != nil {
+		fail("delete object", err)
+	}
+
+	if len(failures) > 0 {
+		return accessCheckError(failures)
+	}
+
+	log.Info("Access check passed")
+	return nil
+}
+
+// checkProbeObject reads back the probe object and compares its content.
+func (o *ObjectStore) checkProbeObject(bucket, key string, expected []byte) error {
+	body, err := o.GetObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	actual, err := ioutil.ReadAll(body)
+	if err != nil {
+		return errors.Wrap(err, "error reading object")
+	}
+
+	if !bytes.Equal(actual, expected) {
+		return errors.New("object content does not match what was written")
+	}
+
+	return nil
+}
+
+func accessCheckError(failures []string) error {
+	return errors.Errorf("backup storage location failed access check: %s", strings.Join(failures, "; "))
+}
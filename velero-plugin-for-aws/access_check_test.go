@@ -0,0 +1,113 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCheckAccess(t *testing.T) {
+	accessDenied := awserr.New("AccessDenied", "Access Denied", nil)
+
+	tests := []struct {
+		name          string
+		headErr       error
+		listErr       error
+		putErr        error
+		getBody       string
+		getErr        error
+		deleteErr     error
+		expectedError string
+	}{
+		{
+			name: "all checks pass",
+		},
+		{
+			name:          "bucket unreachable",
+			headErr:       awserr.New(notFoundCode, "Not Found", nil),
+			expectedError: "backup storage location failed access check: head bucket: NotFound: Not Found",
+		},
+		{
+			name:          "list and put denied",
+			listErr:       accessDenied,
+			putErr:        accessDenied,
+			expectedError: "backup storage location failed access check: list objects: AccessDenied: Access Denied; put object (SSE-KMS key my-key): error putting object prefix/.velero-access-check-",
+		},
+		{
+			name:          "get returns other content and delete denied",
+			getBody:       "something else",
+			deleteErr:     accessDenied,
+			expectedError: "backup storage location failed access check: get object: object content does not match what was written; delete object: error deleting object prefix/.velero-access-check-",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mockS3)
+			u := new(mockS3Uploader)
+
+			o := &ObjectStore{
+				log:        newLogger(),
+				s3:         s,
+				s3Uploader: u,
+				kmsKeyID:   "my-key",
+			}
+
+			var key, body string
+
+			s.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, tc.headErr)
+			s.On("ListObjectsV2PagesWithContext", mock.Anything, mock.Anything).Return(tc.listErr)
+			u.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+				input := args.Get(0).(*s3manager.UploadInput)
+				key = *input.Key
+				content, _ := ioutil.ReadAll(input.Body)
+				body = string(content)
+				assert.Equal(t, "my-key", *input.SSEKMSKeyId)
+			}).Return(&s3manager.UploadOutput{}, tc.putErr)
+			getOutput := &s3.GetObjectOutput{}
+			s.On("GetObjectWithContext", mock.Anything).Run(func(args mock.Arguments) {
+				assert.Equal(t, key, *args.Get(0).(*s3.GetObjectInput).Key)
+				content := body
+				if tc.getBody != "" {
+					content = tc.getBody
+				}
+				getOutput.Body = ioutil.NopCloser(strings.NewReader(content))
+			}).Return(getOutput, tc.getErr)
+			s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, tc.deleteErr)
+
+			err := o.checkAccess("bucket", "prefix")
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(key, "prefix/"+accessCheckKeyPrefix))
+				s.AssertCalled(t, "DeleteObjectWithContext", mock.Anything)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedError)
+			}
+		})
+	}
+}
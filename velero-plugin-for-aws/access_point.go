@@ -0,0 +1,92 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/pkg/errors"
+)
+
+// parseAccessPointARN returns the parsed ARN if bucket is the ARN of an S3
+// Access Point or of an S3 on Outposts access point rather than a bucket
+// name, or nil if it's a bucket name. The SDK sends requests for an access
+// point ARN to the access point's endpoint and signs them for its region.
+//
+// Multi-Region Access Points must be signed with SigV4A, which the AWS SDK
+// for Go v1 doesn't implement in any version, so their ARNs are rejected.
+func parseAccessPointARN(bucket string) (*arn.ARN, error) {
+	if !arn.IsARN(bucket) {
+		return nil, nil
+	}
+
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse bucket ARN %s", bucket)
+	}
+
+	switch {
+	case parsed.Service == "s3-outposts":
+		if !outpostAccessPointPattern.MatchString(parsed.Resource) {
+			return nil, errors.Errorf("invalid bucket %s: only S3 on Outposts access point ARNs are supported", bucket)
+		}
+	case parsed.Service != "s3" || !strings.HasPrefix(parsed.Resource, "accesspoint"):
+		return nil, errors.Errorf("invalid bucket %s: only S3 Access Point and S3 on Outposts access point ARNs are supported", bucket)
+	case parsed.Region == "":
+		return nil, errors.Errorf("invalid bucket %s: Multi-Region Access Point ARNs aren't supported, because their requests must be signed with SigV4A", bucket)
+	}
+
+	return &parsed, nil
+}
+
+// outpostAccessPointPattern matches the resource of an S3 on Outposts access
+// point ARN, e.g. "outpost/op-01ac5d28a6a232904/accesspoint/velero". Objects
+// on Outposts can only be reached through an access point, not by the
+// bucket's own ARN.
+var outpostAccessPointPattern = regexp.MustCompile(`^outpost[/:][^/:]+[/:]accesspoint[/:][^/:]+$`)
+
+// validateAccessPointConfig returns an error if config can't be used with
+// the access point. Its endpoint is resolved from its ARN, so it can't be
+// used with a custom endpoint, and requests to it must be signed with
+// signature version 4. Outposts have no dual-stack endpoints.
+func validateAccessPointConfig(accessPoint *arn.ARN, config map[string]string, endpointOpts endpointOptions) error {
+	for _, key := range []string{s3URLKey, publicURLKey, publicEndpointsKey} {
+		if config[key] != "" {
+			return errors.Errorf("%s can't be used with an access point ARN bucket", key)
+		}
+	}
+
+	if endpointOpts.accelerate {
+		return errors.Errorf("%s can't be used with an access point ARN bucket", useAccelerateKey)
+	}
+
+	if endpointOpts.dualStack && accessPoint.Service == "s3-outposts" {
+		return errors.Errorf("%s can't be used with an S3 on Outposts access point ARN bucket", useDualStackKey)
+	}
+
+	if version := config[signatureVersionKey]; version != "" && version != "4" {
+		return errors.Errorf("invalid signature version: %s (access points require version 4)", version)
+	}
+
+	if region := config[regionKey]; region != "" && region != accessPoint.Region {
+		return errors.Errorf("invalid %s: %s doesn't match the access point's region %s", regionKey, region, accessPoint.Region)
+	}
+
+	return nil
+}
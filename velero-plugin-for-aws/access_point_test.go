@@ -0,0 +1,249 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAccessPointARN        = "arn:aws:s3:us-west-2:123456789012:accesspoint/velero"
+	testOutpostAccessPointARN = "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/velero"
+)
+
+func TestParseAccessPointARN(t *testing.T) {
+	tests := []struct {
+		bucket         string
+		expectedRegion string
+		expectedErr    string
+	}{
+		{
+			bucket: "velero-backups",
+		},
+		{
+			bucket:         testAccessPointARN,
+			expectedRegion: "us-west-2",
+		},
+		{
+			bucket:         "arn:aws:s3:us-west-2:123456789012:accesspoint:velero",
+			expectedRegion: "us-west-2",
+		},
+		{
+			bucket:      "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap",
+			expectedErr: "Multi-Region Access Point ARNs aren't supported, because their requests must be signed with SigV4A",
+		},
+		{
+			bucket:         testOutpostAccessPointARN,
+			expectedRegion: "us-west-2",
+		},
+		{
+			bucket:      "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/bucket/velero",
+			expectedErr: "only S3 on Outposts access point ARNs are supported",
+		},
+		{
+			bucket:      "arn:aws:s3:::velero-backups",
+			expectedErr: "only S3 Access Point and S3 on Outposts access point ARNs are supported",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.bucket, func(t *testing.T) {
+			accessPoint, err := parseAccessPointARN(tc.bucket)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			if tc.expectedRegion == "" {
+				assert.Nil(t, accessPoint)
+			} else {
+				assert.Equal(t, tc.expectedRegion, accessPoint.Region)
+			}
+		})
+	}
+}
+
+func TestValidateAccessPointConfig(t *testing.T) {
+	accessPoint, err := parseAccessPointARN(testAccessPointARN)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		bucket      string
+		config      map[string]string
+		endpoints   endpointOptions
+		expectedErr string
+	}{
+		{
+			name:   "region matches",
+			config: map[string]string{regionKey: "us-west-2", signatureVersionKey: "4"},
+		},
+		{
+			name:        "region doesn't match",
+			config:      map[string]string{regionKey: "us-east-1"},
+			expectedErr: "invalid region: us-east-1 doesn't match the access point's region us-west-2",
+		},
+		{
+			name:        "s3Url",
+			config:      map[string]string{s3URLKey: "http://minio:9000"},
+			expectedErr: "s3Url can't be used with an access point ARN bucket",
+		},
+		{
+			name:        "publicUrl",
+			config:      map[string]string{publicURLKey: "https://s3.example.com"},
+			expectedErr: "publicUrl can't be used with an access point ARN bucket",
+		},
+		{
+			name:        "accelerate",
+			config:      map[string]string{},
+			endpoints:   endpointOptions{accelerate: true},
+			expectedErr: "useAccelerate can't be used with an access point ARN bucket",
+		},
+		{
+			name:        "signature version 1",
+			config:      map[string]string{signatureVersionKey: "1"},
+			expectedErr: "invalid signature version: 1 (access points require version 4)",
+		},
+		{
+			name:        "dual-stack on Outposts",
+			bucket:      testOutpostAccessPointARN,
+			config:      map[string]string{},
+			endpoints:   endpointOptions{dualStack: true},
+			expectedErr: "useDualStack can't be used with an S3 on Outposts access point ARN bucket",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			accessPoint := accessPoint
+			if tc.bucket != "" {
+				var err error
+				accessPoint, err = parseAccessPointARN(tc.bucket)
+				require.NoError(t, err)
+			}
+
+			err := validateAccessPointConfig(accessPoint, tc.config, tc.endpoints)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestInitAccessPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name            string
+		bucket          string
+		config          map[string]string
+		expectedHost    string
+		expectedService string
+	}{
+		{
+			name:            "default",
+			bucket:          testAccessPointARN,
+			config:          map[string]string{},
+			expectedHost:    "velero-123456789012.s3-accesspoint.us-west-2.amazonaws.com",
+			expectedService: "s3",
+		},
+		{
+			name:            "dual-stack",
+			bucket:          testAccessPointARN,
+			config:          map[string]string{useDualStackKey: "true"},
+			expectedHost:    "velero-123456789012.s3-accesspoint.dualstack.us-west-2.amazonaws.com",
+			expectedService: "s3",
+		},
+		{
+			name:            "FIPS",
+			bucket:          testAccessPointARN,
+			config:          map[string]string{useFIPSKey: "true", regionKey: "us-west-2"},
+			expectedHost:    "velero-123456789012.s3-accesspoint-fips.us-west-2.amazonaws.com",
+			expectedService: "s3",
+		},
+		{
+			name:            "Outposts",
+			bucket:          testOutpostAccessPointARN,
+			config:          map[string]string{},
+			expectedHost:    "velero-123456789012.op-01ac5d28a6a232904.s3-outposts.us-west-2.amazonaws.com",
+			expectedService: "s3-outposts",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config[bucketKey] = tc.bucket
+			tc.config[credentialsFileKey] = writeCredentialsFile(t, dir)
+
+			// the region is taken from the ARN rather than looked up
+			o := newObjectStore(newLogger())
+			require.NoError(t, o.Init(tc.config))
+			assert.Equal(t, "us-west-2", o.region)
+
+			signed, err := o.CreateSignedURL(tc.bucket, "backups/b1/b1-logs.gz", time.Minute)
+			require.NoError(t, err)
+
+			u, err := url.Parse(signed)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, u.Host)
+			assert.Equal(t, "/backups/b1/b1-logs.gz", u.Path)
+			assert.Contains(t, u.Query().Get("X-Amz-Credential"), "/us-west-2/"+tc.expectedService+"/aws4_request")
+		})
+	}
+
+	o := newObjectStore(newLogger())
+	err = o.Init(map[string]string{
+		bucketKey:          testAccessPointARN,
+		s3URLKey:           "http://minio:9000",
+		credentialsFileKey: writeCredentialsFile(t, dir),
+	})
+	assert.EqualError(t, err, "s3Url can't be used with an access point ARN bucket")
+}
+
+func TestGetBucketRegionAccessPoint(t *testing.T) {
+	// the region is read from the ARN without making any requests
+	region, err := GetBucketRegion(testAccessPointARN, session.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", region)
+
+	_, err = GetBucketRegion("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", session.Options{})
+	assert.Error(t, err)
+}
+
+func TestCopySourceAccessPoint(t *testing.T) {
+	assert.Equal(t, "bucket/backups/b1/b%201.tar.gz", copySource("bucket", "backups/b1/b 1.tar.gz"))
+	assert.Equal(t,
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/velero/object/backups/b1/b%201.tar.gz",
+		copySource(testAccessPointARN, "backups/b1/b 1.tar.gz"))
+	assert.Equal(t,
+		"arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01ac5d28a6a232904/accesspoint/velero/object/backups/b1/b%201.tar.gz",
+		copySource(testOutpostAccessPointARN, "backups/b1/b 1.tar.gz"))
+}
@@ -0,0 +1,142 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const aclKey = "acl"
+
+const (
+	// ownershipControlsNotFoundCode is returned for a bucket without
+	// Object Ownership settings, which therefore uses ACLs.
+	ownershipControlsNotFoundCode = "OwnershipControlsNotFoundError"
+	// aclNotSupportedCode is returned for a request with an ACL to a bucket
+	// that disables ACLs.
+	aclNotSupportedCode = "AccessControlListNotSupported"
+)
+
+// validCannedACLs are the canned ACLs that can be set on an object.
+var validCannedACLs = map[string]bool{
+	s3.ObjectCannedACLPrivate:                true,
+	s3.ObjectCannedACLPublicRead:             true,
+	s3.ObjectCannedACLPublicReadWrite:        true,
+	s3.ObjectCannedACLAuthenticatedRead:      true,
+	s3.ObjectCannedACLAwsExecRead:            true,
+	s3.ObjectCannedACLBucketOwnerRead:        true,
+	s3.ObjectCannedACLBucketOwnerFullControl: true,
+}
+
+func parseACL(config map[string]string) (string, error) {
+	acl := config[aclKey]
+	if acl != "" && !validCannedACLs[acl] {
+		return "", errors.Errorf("invalid %s: %s is not a canned ACL", aclKey, acl)
+	}
+	return acl, nil
+}
+
+// bucketOwnershipEnforced caches, by endpoint and bucket, whether a
+// bucket enforces object ownership, so that it's only looked up once per
+// process rather than each time a location is initialized.
+var (
+	bucketOwnershipEnforcedLock sync.Mutex
+	bucketOwnershipEnforced     = map[string]bool{}
+)
+
+// bucketEnforcesOwnership returns whether the bucket's Object Ownership is
+// BucketOwnerEnforced, in which case S3 rejects requests that set an ACL.
+func bucketEnforcesOwnership(client *s3.S3, bucket string) (bool, error) {
+	cacheKey := client.Endpoint + "/" + bucket
+
+	bucketOwnershipEnforcedLock.Lock()
+	enforced, ok := bucketOwnershipEnforced[cacheKey]
+	bucketOwnershipEnforcedLock.Unlock()
+	if ok {
+		return enforced, nil
+	}
+
+	output, err := client.GetBucketOwnershipControls(&s3.GetBucketOwnershipControlsInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != ownershipControlsNotFoundCode {
+		return false, errors.Wrapf(err, "error getting object ownership controls of bucket %s", bucket)
+	}
+
+	if err == nil && output.OwnershipControls != nil {
+		for _, rule := range output.OwnershipControls.Rules {
+			if aws.StringValue(rule.ObjectOwnership) == s3.ObjectOwnershipBucketOwnerEnforced {
+				enforced = true
+				break
+			}
+		}
+	}
+
+	bucketOwnershipEnforcedLock.Lock()
+	bucketOwnershipEnforced[cacheKey] = enforced
+	bucketOwnershipEnforcedLock.Unlock()
+
+	return enforced, nil
+}
+
+// initACL returns the ACL to set on the location's objects, which is none if
+// the bucket enforces object ownership. That's only looked up if acl is set.
+// If it can't be determined, e.g. because the location's credentials belong
+// to another account than the bucket, acl is used.
+func initACL(acl string, client *s3.S3, bucket string, log logrus.FieldLogger) string {
+	if acl == "" {
+		return ""
+	}
+
+	log = log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"acl":    acl,
+	})
+
+	enforced, err := bucketEnforcesOwnership(client, bucket)
+	if err != nil {
+		log.WithError(err).Debug("Could not determine whether the bucket enforces object ownership, using the ACL")
+		return acl
+	}
+	if enforced {
+		log.Info("Bucket enforces object ownership, so the ACL isn't set on objects")
+		return ""
+	}
+
+	return acl
+}
+
+// objectACL returns the canned ACL to set on the location's objects, or nil
+// if there's none.
+func (o *ObjectStore) objectACL() *string {
+	if o.acl == "" {
+		return nil
+	}
+	return aws.String(o.acl)
+}
+
+// explainACLError adds to err why S3 rejected a request with the location's
+// ACL, if it did.
+func (o *ObjectStore) explainACLError(err error) error {
+	if o.acl == "" || errorCode(err) != aclNotSupportedCode {
+		return err
+	}
+	return errors.WithMessagef(err, "the bucket enforces object ownership, so %s must not be set", aclKey)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseACL(t *testing.T) {
+	acl, err := parseACL(map[string]string{aclKey: "bucket-owner-full-control"})
+	require.NoError(t, err)
+	assert.Equal(t, "bucket-owner-full-control", acl)
+
+	_, err = parseACL(map[string]string{aclKey: "bucket-owner"})
+	assert.EqualError(t, err, "invalid acl: bucket-owner is not a canned ACL")
+}
+
+func TestInitACL(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		response    string
+		expectedACL string
+	}{
+		{
+			name:        "ACLs enabled",
+			status:      http.StatusOK,
+			response:    `<OwnershipControls><Rule><ObjectOwnership>BucketOwnerPreferred</ObjectOwnership></Rule></OwnershipControls>`,
+			expectedACL: "bucket-owner-full-control",
+		},
+		{
+			name:     "bucket owner enforced",
+			status:   http.StatusOK,
+			response: `<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`,
+		},
+		{
+			name:        "no ownership controls",
+			status:      http.StatusNotFound,
+			response:    `<Error><Code>OwnershipControlsNotFoundError</Code><Message>The bucket ownership controls were not found</Message></Error>`,
+			expectedACL: "bucket-owner-full-control",
+		},
+		{
+			name:        "another account's bucket",
+			status:      http.StatusForbidden,
+			response:    `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`,
+			expectedACL: "bucket-owner-full-control",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.RawQuery
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.response))
+			}))
+			defer server.Close()
+
+			o := newObjectStore(newLogger())
+			require.NoError(t, o.Init(map[string]string{
+				bucketKey:           "bucket",
+				regionKey:           "us-east-1",
+				s3URLKey:            server.URL,
+				s3ForcePathStyleKey: "true",
+				credentialsFileKey:  writeCredentialsFile(t, dir),
+				aclKey:              "bucket-owner-full-control",
+			}))
+
+			assert.Equal(t, "ownershipControls=", query)
+			assert.Equal(t, tc.expectedACL, o.acl)
+		})
+	}
+}
+
+func TestInitACLCachesOwnership(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["ownershipControls"]; ok {
+			requests++
+		}
+		_, _ = w.Write([]byte(`<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := map[string]string{
+		bucketKey:           "bucket",
+		regionKey:           "us-east-1",
+		s3URLKey:            server.URL,
+		s3ForcePathStyleKey: "true",
+		credentialsFileKey:  writeCredentialsFile(t, dir),
+	}
+
+	// without an ACL, the bucket's ownership isn't looked up
+	o := newObjectStore(newLogger())
+	require.NoError(t, o.Init(config))
+	assert.Equal(t, 0, requests)
+
+	config[aclKey] = "bucket-owner-full-control"
+	for i := 0; i < 2; i++ {
+		o := newObjectStore(newLogger())
+		require.NoError(t, o.Init(config))
+		assert.Empty(t, o.acl)
+	}
+	assert.Equal(t, 1, requests)
+}
+
+func TestPutObjectACL(t *testing.T) {
+	uploader := new(mockS3Uploader)
+	o := &ObjectStore{log: newLogger(), s3Uploader: uploader, acl: "bucket-owner-full-control"}
+
+	uploader.On("UploadWithContext", mock.MatchedBy(func(input *s3manager.UploadInput) bool {
+		return aws.StringValue(input.Key) == "backups/b1/b1.tar.gz"
+	})).Return(&s3manager.UploadOutput{}, nil)
+	uploader.On("UploadWithContext", mock.Anything).Return(&s3manager.UploadOutput{}, awserr.New(aclNotSupportedCode, "The bucket does not allow ACLs", nil))
+
+	require.NoError(t, o.PutObject("bucket", "backups/b1/b1.tar.gz", strings.NewReader("contents")))
+	input := uploader.Calls[0].Arguments.Get(0).(*s3manager.UploadInput)
+	assert.Equal(t, "bucket-owner-full-control", aws.StringValue(input.ACL))
+
+	err := o.PutObject("bucket", "backups/b1/velero-backup.json", strings.NewReader("{}"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the bucket enforces object ownership, so acl must not be set")
+}
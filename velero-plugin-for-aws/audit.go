@@ -0,0 +1,184 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const auditLogPathKey = "auditLogPath"
+
+// audit entry outcomes
+const (
+	auditOutcomeSuccess  = "success"
+	auditOutcomeFailure  = "failure"
+	auditOutcomeNotFound = "notFound"
+)
+
+// auditEntry is a single line of the audit log.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Principal  string    `json:"principal"`
+	Region     string    `json:"region,omitempty"`
+	Bucket     string    `json:"bucket,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	TrashKey   string    `json:"trashKey,omitempty"`
+	VolumeID   string    `json:"volumeId,omitempty"`
+	SnapshotID string    `json:"snapshotId,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// auditSink appends JSON lines to a writer. Writes are serialized so that
+// concurrent operations never interleave their entries.
+type auditSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (s *auditSink) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	line = append(line, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err = s.w.Write(line)
+	return errors.WithStack(err)
+}
+
+// auditSinks holds the open audit log files, shared by every plugin
+// instance in the process that logs to the same path.
+var (
+	auditSinksLock sync.Mutex
+	auditSinks     = map[string]*auditSink{}
+)
+
+// getAuditSink returns the sink for the audit log at path, opening the file
+// for appending, and creating it if necessary, the first time it's used.
+func getAuditSink(path string) (*auditSink, error) {
+	auditSinksLock.Lock()
+	defer auditSinksLock.Unlock()
+
+	if sink, ok := auditSinks[path]; ok {
+		return sink, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening audit log %s", path)
+	}
+
+	sink := &auditSink{w: file}
+	auditSinks[path] = sink
+
+	return sink, nil
+}
+
+// auditLogger records the create, delete and presign operations of a
+// plugin instance. A nil *auditLogger records nothing.
+type auditLogger struct {
+	log       logrus.FieldLogger
+	sink      *auditSink
+	principal string
+	region    string
+}
+
+// newAuditLogger returns an audit logger writing to the log at path on behalf
+// of the session's credentials, or nil if path is empty. The principal is the
+// caller's ARN from STS when useSTS is true, and otherwise the access key ID,
+// e.g. for S3-compatible stores that don't implement STS.
+func newAuditLogger(path string, sess *session.Session, useSTS bool, region string, log logrus.FieldLogger) (*auditLogger, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	sink, err := getAuditSink(path)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, err := getPrincipal(sess, useSTS)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("principal", principal).Infof("Recording audit log to %s", path)
+
+	return &auditLogger{
+		log:       log,
+		sink:      sink,
+		principal: principal,
+		region:    region,
+	}, nil
+}
+
+func getPrincipal(sess *session.Session, useSTS bool) (string, error) {
+	if useSTS {
+		res, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			return "", errors.Wrap(err, "error getting caller identity for audit log")
+		}
+		return aws.StringValue(res.Arn), nil
+	}
+
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return "", errors.Wrap(err, "error getting credentials for audit log")
+	}
+	return "accessKeyId:" + creds.AccessKeyID, nil
+}
+
+// record appends entry to the audit log, filling in the time, principal,
+// region and, unless already set, the outcome based on err. A failure to
+// write the entry is logged rather than returned because the operation it
+// describes has already happened.
+func (a *auditLogger) record(entry auditEntry, err error) {
+	if a == nil {
+		return
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Principal = a.principal
+	entry.Region = a.region
+
+	if entry.Outcome == "" {
+		entry.Outcome = auditOutcomeSuccess
+		if err != nil {
+			entry.Outcome = auditOutcomeFailure
+			entry.Error = err.Error()
+		}
+	}
+
+	if err := a.sink.write(entry); err != nil {
+		a.log.WithError(err).WithField("operation", entry.Operation).Error("Error writing audit log entry")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditEntries(t *testing.T, buf *bytes.Buffer) []auditEntry {
+	var entries []auditEntry
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var entry auditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestDeleteObjectAudit(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	buf := new(bytes.Buffer)
+	o := &ObjectStore{
+		log: newLogger(),
+		s3:  s,
+		audit: &auditLogger{
+			log:       newLogger(),
+			sink:      &auditSink{w: buf},
+			principal: "arn:aws:iam::123456789012:user/velero",
+			region:    "us-east-1",
+		},
+	}
+
+	s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, nil).Once()
+	s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, awserr.New("AccessDenied", "Access Denied", nil)).Once()
+
+	assert.NoError(t, o.DeleteObject("bucket", "backups/a"))
+	assert.Error(t, o.DeleteObject("bucket", "backups/b"))
+
+	entries := readAuditEntries(t, buf)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "ObjectStore.DeleteObject", entries[0].Operation)
+	assert.Equal(t, "arn:aws:iam::123456789012:user/velero", entries[0].Principal)
+	assert.Equal(t, "us-east-1", entries[0].Region)
+	assert.Equal(t, "bucket", entries[0].Bucket)
+	assert.Equal(t, "backups/a", entries[0].Key)
+	assert.Equal(t, auditOutcomeSuccess, entries[0].Outcome)
+	assert.Empty(t, entries[0].Error)
+
+	assert.Equal(t, "backups/b", entries[1].Key)
+	assert.Equal(t, auditOutcomeFailure, entries[1].Outcome)
+	assert.Equal(t, "error deleting object backups/b: AccessDenied: Access Denied", entries[1].Error)
+}
+
+func TestDeleteSnapshotNotFoundAudit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`<Response><Errors><Error><Code>InvalidSnapshot.NotFound</Code><Message>not found</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithMaxRetries(0))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	b := &VolumeSnapshotter{
+		log:   newLogger(),
+		ec2:   ec2.New(sess),
+		audit: &auditLogger{log: newLogger(), sink: &auditSink{w: buf}},
+	}
+
+	require.NoError(t, b.DeleteSnapshot("snap-1"))
+
+	entries := readAuditEntries(t, buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "VolumeSnapshotter.DeleteSnapshot", entries[0].Operation)
+	assert.Equal(t, "snap-1", entries[0].SnapshotID)
+	assert.Equal(t, auditOutcomeNotFound, entries[0].Outcome)
+}
+
+func TestNilAuditLogger(t *testing.T) {
+	var a *auditLogger
+	assert.NotPanics(t, func() { a.record(auditEntry{Operation: "ObjectStore.DeleteObject"}, nil) })
+}
+
+func TestGetAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := getAuditSink(path)
+	require.NoError(t, err)
+
+	// plugin instances logging to the same path share a sink
+	other, err := getAuditSink(path)
+	require.NoError(t, err)
+	assert.Same(t, sink, other)
+
+	require.NoError(t, sink.write(auditEntry{Operation: "ObjectStore.PutObject", Outcome: auditOutcomeSuccess}))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"operation":"ObjectStore.PutObject"`)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,178 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// maxDeleteObjects is the most keys a single DeleteObjects request can delete.
+const maxDeleteObjects = 1000
+
+// deleteFailure is a key, or a version of it, that DeleteObjects couldn't delete.
+type deleteFailure struct {
+	Key       string
+	VersionID string
+	Code      string
+	Message   string
+}
+
+func (f deleteFailure) String() string {
+	if f.VersionID != "" {
+		return fmt.Sprintf("%s (version %s): %s: %s", f.Key, f.VersionID, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Key, f.Code, f.Message)
+}
+
+// batchDeleteError reports every key that a batched delete failed to delete.
+type batchDeleteError struct {
+	failures []deleteFailure
+}
+
+func (e *batchDeleteError) Error() string {
+	failures := make([]string, 0, len(e.failures))
+	for _, f := range e.failures {
+		failures = append(failures, f.String())
+	}
+	return fmt.Sprintf("failed to delete %d objects: %s", len(e.failures), strings.Join(failures, "; "))
+}
+
+// deleteBatch deletes up to maxDeleteObjects objects with a single
+// DeleteObjects request, returning the objects S3 failed to delete. The
+// returned error is only set if the request as a whole failed.
+func (o *ObjectStore) deleteBatch(ctx aws.Context, bucket string, ids []*s3.ObjectIdentifier) ([]deleteFailure, error) {
+	res, err := o.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: ids,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var failures []deleteFailure
+	for _, e := range res.Errors {
+		failures = append(failures, deleteFailure{
+			Key:       aws.StringValue(e.Key),
+			VersionID: aws.StringValue(e.VersionId),
+			Code:      aws.StringValue(e.Code),
+			Message:   aws.StringValue(e.Message),
+		})
+	}
+
+	return failures, nil
+}
+
+// deleteObjects deletes keys in batches of up to maxDeleteObjects, in the
+// order given. Because S3 doesn't guarantee the order in which a single
+// request deletes its keys, a pseudo-folder key (ending in "/") is never
+// sent until every key before it has been deleted, which preserves the
+// ordering ListObjects guarantees for providers that return pseudo-folders
+// as objects. Keys that fail are reported in a *batchDeleteError.
+//
+// With soft delete or deleteAllVersions enabled, or with a mirror, each key
+// is deleted with DeleteObject, because none of them can be done with
+// DeleteObjects.
+func (o *ObjectStore) deleteObjects(bucket string, keys []string) (err error) {
+	ctx, span := startSpan("ObjectStore.deleteObjects", bucketAttribute.String(bucket))
+	defer func() { endSpan(span, err) }()
+
+	if err = o.checkWritable("DeleteObjects", bucket, ""); err != nil {
+		return err
+	}
+
+	var failures []deleteFailure
+
+	if o.trash.enabled || o.deleteAllVersions || o.mirror != nil {
+		for _, key := range keys {
+			if err := o.DeleteObject(bucket, key); err != nil {
+				failures = append(failures, deleteFailure{Key: key, Code: errorCode(err), Message: err.Error()})
+			}
+		}
+		return batchDeleteResult(failures)
+	}
+
+	var batch []*s3.ObjectIdentifier
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		batchFailures, err := o.deleteBatch(ctx, bucket, batch)
+		if err != nil {
+			return errors.Wrapf(err, "error deleting %d objects", len(batch))
+		}
+		failures = append(failures, batchFailures...)
+
+		failed := make(map[string]string, len(batchFailures))
+		for _, f := range batchFailures {
+			failed[f.Key] = f.String()
+		}
+		for _, id := range batch {
+			var err error
+			if msg, ok := failed[aws.StringValue(id.Key)]; ok {
+				err = errors.New(msg)
+			}
+			o.audit.record(auditEntry{Operation: "ObjectStore.DeleteObjects", Bucket: bucket, Key: aws.StringValue(id.Key)}, err)
+		}
+
+		batch = nil
+		return nil
+	}
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") || len(batch) == maxDeleteObjects {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	o.log.WithField("bucket", bucket).Debugf("Deleted %d objects, %d failed", len(keys)-len(failures), len(failures))
+
+	return batchDeleteResult(failures)
+}
+
+// deletePrefix deletes every object under prefix, e.g. "backups/<name>/",
+// with as few requests as possible. Velero deletes a backup's objects one
+// DeleteObject at a time, so this is run by the delete-prefix command.
+func (o *ObjectStore) deletePrefix(bucket, prefix string) error {
+	keys, err := o.ListObjects(bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	return o.deleteObjects(bucket, keys)
+}
+
+func batchDeleteResult(failures []deleteFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return &batchDeleteError{failures: failures}
+}
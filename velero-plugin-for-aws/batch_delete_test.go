@@ -0,0 +1,153 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// recordBatches records the keys of every DeleteObjects request.
+func recordBatches(s *mockS3, output *s3.DeleteObjectsOutput, err error) *[][]string {
+	batches := new([][]string)
+	s.On("DeleteObjectsWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		var keys []string
+		for _, id := range args.Get(0).(*s3.DeleteObjectsInput).Delete.Objects {
+			keys = append(keys, *id.Key)
+		}
+		*batches = append(*batches, keys)
+	}).Return(output, err)
+	return batches
+}
+
+func TestDeleteObjectsChunks(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+	batches := recordBatches(s, &s3.DeleteObjectsOutput{}, nil)
+
+	var keys []string
+	for i := 0; i < 2*maxDeleteObjects+1; i++ {
+		keys = append(keys, fmt.Sprintf("restic/default/data/%04d", i))
+	}
+
+	require.NoError(t, o.deleteObjects("bucket", keys))
+	require.Len(t, *batches, 3)
+	assert.Len(t, (*batches)[0], maxDeleteObjects)
+	assert.Len(t, (*batches)[1], maxDeleteObjects)
+	assert.Equal(t, []string{keys[2*maxDeleteObjects]}, (*batches)[2])
+}
+
+func TestDeleteObjectsPseudoFolders(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+	batches := recordBatches(s, &s3.DeleteObjectsOutput{}, nil)
+
+	// in the reverse-sorted order ListObjects returns
+	keys := []string{
+		"backups/b1/velero-backup.json",
+		"backups/b1/sub/file",
+		"backups/b1/sub/",
+		"backups/b1/b1.tar.gz",
+		"backups/b1/",
+	}
+
+	require.NoError(t, o.deleteObjects("bucket", keys))
+	assert.Equal(t, [][]string{
+		{"backups/b1/velero-backup.json", "backups/b1/sub/file"},
+		{"backups/b1/sub/", "backups/b1/b1.tar.gz"},
+		{"backups/b1/"},
+	}, *batches)
+}
+
+func TestDeleteObjectsFailures(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+	recordBatches(s, &s3.DeleteObjectsOutput{
+		Errors: []*s3.Error{
+			{Key: aws.String("b"), Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")},
+		},
+	}, nil)
+
+	err := o.deleteObjects("bucket", []string{"a", "b", "c"})
+	require.Error(t, err)
+
+	batchErr, ok := err.(*batchDeleteError)
+	require.True(t, ok)
+	assert.Equal(t, []deleteFailure{{Key: "b", Code: "AccessDenied", Message: "Access Denied"}}, batchErr.failures)
+	assert.Equal(t, "failed to delete 1 objects: b: AccessDenied: Access Denied", err.Error())
+}
+
+func TestDeleteObjectsRequestError(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+	batches := recordBatches(s, &s3.DeleteObjectsOutput{}, awserr.New("AccessDenied", "Access Denied", nil))
+
+	// deletion stops at the first failed request so that pseudo-folders
+	// are never deleted before their contents
+	err := o.deleteObjects("bucket", []string{"a/b", "a/"})
+	require.Error(t, err)
+	assert.Equal(t, "error deleting 1 objects: AccessDenied: Access Denied", err.Error())
+	assert.Len(t, *batches, 1)
+}
+
+func TestDeleteObjectsSoftDelete(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(1)}, nil)
+	s.On("CopyObjectWithContext", mock.Anything).Return(&s3.CopyObjectOutput{}, nil)
+	s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, nil).Twice()
+
+	require.NoError(t, o.deleteObjects("bucket", []string{"a", "b"}))
+	s.AssertNotCalled(t, "DeleteObjectsWithContext", mock.Anything)
+}
+
+func TestDeletePrefix(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+
+	s.On("ListObjectsV2PagesWithContext", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		fn := args.Get(1).(func(*s3.ListObjectsV2Output, bool) bool)
+		fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+			{Key: aws.String("backups/b1/")},
+			{Key: aws.String("backups/b1/b1.tar.gz")},
+		}}, true)
+	}).Return(nil)
+	batches := recordBatches(s, &s3.DeleteObjectsOutput{}, nil)
+
+	require.NoError(t, o.deletePrefix("bucket", "backups/b1/"))
+	assert.Equal(t, [][]string{{"backups/b1/b1.tar.gz"}, {"backups/b1/"}}, *batches)
+}
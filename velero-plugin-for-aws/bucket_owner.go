@@ -0,0 +1,134 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+const (
+	requesterPaysKey       = "requesterPays"
+	expectedBucketOwnerKey = "expectedBucketOwner"
+)
+
+// The headers are set directly rather than through each operation's
+// ExpectedBucketOwner and RequestPayer parameters, so that they're sent with
+// every request, including those s3manager makes.
+const (
+	requestPayerHeader              = "x-amz-request-payer"
+	expectedBucketOwnerHeader       = "x-amz-expected-bucket-owner"
+	sourceExpectedBucketOwnerHeader = "x-amz-source-expected-bucket-owner"
+	requestPayerRequester           = "requester"
+)
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// bucketOwnerOptions are sent with every request to the location's bucket.
+type bucketOwnerOptions struct {
+	// requesterPays acknowledges that the location's account is charged
+	// for its requests to a Requester Pays bucket, which S3 rejects
+	// otherwise.
+	requesterPays bool
+	// expectedOwner is the ID of the account the bucket must belong to.
+	// S3 rejects requests to a bucket of any other account, so that a
+	// bucket of the same name created by someone else after the location's
+	// was deleted is never written to or read from.
+	expectedOwner string
+}
+
+func parseBucketOwnerOptions(config map[string]string) (bucketOwnerOptions, error) {
+	var opts bucketOwnerOptions
+
+	if val := config[requesterPaysKey]; val != "" {
+		requesterPays, err := strconv.ParseBool(val)
+		if err != nil {
+			return bucketOwnerOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", requesterPaysKey)
+		}
+		opts.requesterPays = requesterPays
+	}
+
+	if owner := config[expectedBucketOwnerKey]; owner != "" {
+		if !accountIDPattern.MatchString(owner) {
+			return bucketOwnerOptions{}, errors.Errorf("invalid %s: %s (expected a 12-digit AWS account ID)", expectedBucketOwnerKey, owner)
+		}
+		opts.expectedOwner = owner
+	}
+
+	return opts, nil
+}
+
+func (opts bucketOwnerOptions) enabled() bool {
+	return opts.requesterPays || opts.expectedOwner != ""
+}
+
+// buildHandler adds the options to a request. Presigned URLs carry them in
+// the query string, so that clients don't have to send extra headers.
+func (opts bucketOwnerOptions) buildHandler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "velero.BucketOwnerHandler",
+		Fn: func(r *request.Request) {
+			set := r.HTTPRequest.Header.Set
+			if r.IsPresigned() {
+				query := r.HTTPRequest.URL.Query()
+				set = query.Set
+				defer func() { r.HTTPRequest.URL.RawQuery = query.Encode() }()
+			}
+
+			if opts.requesterPays {
+				set(requestPayerHeader, requestPayerRequester)
+			}
+			if opts.expectedOwner != "" {
+				set(expectedBucketOwnerHeader, opts.expectedOwner)
+				// the trash is in the same bucket, so the source of a
+				// copy is checked too
+				switch r.Operation.Name {
+				case "CopyObject", "UploadPartCopy":
+					set(sourceExpectedBucketOwnerHeader, opts.expectedOwner)
+				}
+			}
+		},
+	}
+}
+
+// errorHandler explains the access denied error S3 returns when the bucket
+// doesn't belong to the expected owner, which otherwise looks like a missing
+// permission. It must run after the S3 client's own UnmarshalError handler.
+func (opts bucketOwnerOptions) errorHandler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "velero.BucketOwnerErrorHandler",
+		Fn: func(r *request.Request) {
+			if opts.expectedOwner == "" || r.Error == nil {
+				return
+			}
+
+			failure, ok := r.Error.(awserr.RequestFailure)
+			if !ok || failure.StatusCode() != http.StatusForbidden {
+				return
+			}
+
+			message := fmt.Sprintf("%s (the bucket may not be owned by the %s %s)", failure.Message(), expectedBucketOwnerKey, opts.expectedOwner)
+			r.Error = awserr.NewRequestFailure(awserr.New(failure.Code(), message, failure.OrigErr()), failure.StatusCode(), failure.RequestID())
+		},
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBucketOwnerOptions(t *testing.T) {
+	opts, err := parseBucketOwnerOptions(map[string]string{})
+	require.NoError(t, err)
+	assert.False(t, opts.enabled())
+
+	opts, err = parseBucketOwnerOptions(map[string]string{requesterPaysKey: "true", expectedBucketOwnerKey: "123456789012"})
+	require.NoError(t, err)
+	assert.Equal(t, bucketOwnerOptions{requesterPays: true, expectedOwner: "123456789012"}, opts)
+
+	_, err = parseBucketOwnerOptions(map[string]string{requesterPaysKey: "sure"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not parse requesterPays (expected bool)")
+
+	_, err = parseBucketOwnerOptions(map[string]string{expectedBucketOwnerKey: "security-account"})
+	assert.EqualError(t, err, "invalid expectedBucketOwner: security-account (expected a 12-digit AWS account ID)")
+}
+
+func TestBucketOwnerRequests(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if strings.HasSuffix(r.URL.Path, "/other-account") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := newObjectStore(newLogger())
+	require.NoError(t, o.Init(map[string]string{
+		bucketKey:              "bucket",
+		regionKey:              "us-east-1",
+		s3URLKey:               server.URL,
+		s3ForcePathStyleKey:    "true",
+		credentialsFileKey:     writeCredentialsFile(t, dir),
+		requesterPaysKey:       "true",
+		expectedBucketOwnerKey: "123456789012",
+	}))
+
+	_, err = o.ObjectExists("bucket", "backups/b1/velero-backup.json")
+	require.NoError(t, err)
+	require.NoError(t, o.PutObject("bucket", "backups/b1/velero-backup.json", strings.NewReader("{}")))
+
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		assert.Equal(t, "requester", r.Header.Get("X-Amz-Request-Payer"), r.Method)
+		assert.Equal(t, "123456789012", r.Header.Get("X-Amz-Expected-Bucket-Owner"), r.Method)
+	}
+
+	// S3 rejects requests to another account's bucket as access denied
+	_, err = o.ObjectExists("bucket", "other-account")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the bucket may not be owned by the expectedBucketOwner 123456789012")
+
+	// signed URLs carry them in the query string
+	signed, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "requester", u.Query().Get("x-amz-request-payer"))
+	assert.Equal(t, "123456789012", u.Query().Get("x-amz-expected-bucket-owner"))
+	assert.NotContains(t, u.Query().Get("X-Amz-SignedHeaders"), "x-amz-request-payer")
+}
@@ -0,0 +1,549 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	createBucketIfMissingKey      = "createBucketIfMissing"
+	provisionExistingBucketKey    = "provisionExistingBucket"
+	bucketEncryptionKey           = "bucketEncryption"
+	blockPublicAccessKey          = "blockPublicAccess"
+	abortIncompleteUploadsDaysKey = "abortIncompleteUploadsDays"
+	objectLockModeKey             = "objectLockMode"
+	objectLockRetentionDaysKey    = "objectLockRetentionDays"
+)
+
+const (
+	// bucketEncryptionNone leaves the bucket's default encryption alone.
+	bucketEncryptionNone = "none"
+	// abortIncompleteUploadsRuleID is the ID of the lifecycle rule that
+	// aborts incomplete multipart uploads. Other rules are left alone.
+	abortIncompleteUploadsRuleID = "velero-abort-incomplete-multipart-uploads"
+	// defaultAbortIncompleteUploadsDays is how long incomplete multipart
+	// uploads are kept by default.
+	defaultAbortIncompleteUploadsDays = 7
+)
+
+// provisioningTag is the bucket tag that records how far the plugin got
+// applying the baseline to a bucket it created. It's pending from when the
+// bucket is created until every step succeeded, so that if one fails, the
+// next Init applies the baseline again.
+const (
+	provisioningTag         = "velero.io/provisioning"
+	provisioningTagPending  = "pending"
+	provisioningTagComplete = "complete"
+)
+
+// Error codes S3 returns when a bucket has none of a configuration.
+const (
+	noSuchEncryptionConfigurationCode = "ServerSideEncryptionConfigurationNotFoundError"
+	noSuchPublicAccessBlockCode       = "NoSuchPublicAccessBlockConfiguration"
+	noSuchLifecycleConfigurationCode  = "NoSuchLifecycleConfiguration"
+	noSuchObjectLockConfigurationCode = "ObjectLockConfigurationNotFoundError"
+	noSuchTagSetCode                  = "NoSuchTagSet"
+)
+
+// s3BucketInterface is what provisioning a bucket needs of an S3 client.
+type s3BucketInterface interface {
+	HeadBucketWithContext(ctx aws.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, error)
+	CreateBucketWithContext(ctx aws.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, error)
+	GetBucketEncryptionWithContext(ctx aws.Context, input *s3.GetBucketEncryptionInput, opts ...request.Option) (*s3.GetBucketEncryptionOutput, error)
+	PutBucketEncryptionWithContext(ctx aws.Context, input *s3.PutBucketEncryptionInput, opts ...request.Option) (*s3.PutBucketEncryptionOutput, error)
+	GetPublicAccessBlockWithContext(ctx aws.Context, input *s3.GetPublicAccessBlockInput, opts ...request.Option) (*s3.GetPublicAccessBlockOutput, error)
+	PutPublicAccessBlockWithContext(ctx aws.Context, input *s3.PutPublicAccessBlockInput, opts ...request.Option) (*s3.PutPublicAccessBlockOutput, error)
+	GetBucketLifecycleConfigurationWithContext(ctx aws.Context, input *s3.GetBucketLifecycleConfigurationInput, opts ...request.Option) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfigurationWithContext(ctx aws.Context, input *s3.PutBucketLifecycleConfigurationInput, opts ...request.Option) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	GetBucketVersioningWithContext(ctx aws.Context, input *s3.GetBucketVersioningInput, opts ...request.Option) (*s3.GetBucketVersioningOutput, error)
+	PutBucketVersioningWithContext(ctx aws.Context, input *s3.PutBucketVersioningInput, opts ...request.Option) (*s3.PutBucketVersioningOutput, error)
+	GetObjectLockConfigurationWithContext(ctx aws.Context, input *s3.GetObjectLockConfigurationInput, opts ...request.Option) (*s3.GetObjectLockConfigurationOutput, error)
+	PutObjectLockConfigurationWithContext(ctx aws.Context, input *s3.PutObjectLockConfigurationInput, opts ...request.Option) (*s3.PutObjectLockConfigurationOutput, error)
+	GetBucketTaggingWithContext(ctx aws.Context, input *s3.GetBucketTaggingInput, opts ...request.Option) (*s3.GetBucketTaggingOutput, error)
+	PutBucketTaggingWithContext(ctx aws.Context, input *s3.PutBucketTaggingInput, opts ...request.Option) (*s3.PutBucketTaggingOutput, error)
+}
+
+// bucketProvisioningOptions are the baseline a missing bucket is created
+// with. They're only applied to an existing bucket if provisionExisting is
+// set, or if the plugin created it and didn't finish applying them, so that
+// a bucket that's managed elsewhere is never changed.
+type bucketProvisioningOptions struct {
+	enabled bool
+	// provisionExisting applies the baseline to the bucket even if it
+	// wasn't created by the plugin.
+	provisionExisting bool
+	// encryption is the bucket's default encryption algorithm, or empty to
+	// leave it alone.
+	encryption string
+	// kmsKeyID is the KMS key of aws:kms default encryption.
+	kmsKeyID string
+	// blockPublicAccess blocks all public access to the bucket.
+	blockPublicAccess bool
+	// abortIncompleteUploadsDays is how many days after they were started
+	// incomplete multipart uploads are aborted, or 0 to not abort them.
+	abortIncompleteUploadsDays int64
+	// objectLockMode is the default Object Lock retention mode, or empty
+	// to not use Object Lock.
+	objectLockMode          string
+	objectLockRetentionDays int64
+}
+
+func parseBucketProvisioningOptions(config map[string]string, trash trashOptions, deleteAllVersions bool) (bucketProvisioningOptions, error) {
+	opts := bucketProvisioningOptions{
+		blockPublicAccess:          true,
+		abortIncompleteUploadsDays: defaultAbortIncompleteUploadsDays,
+	}
+
+	if val := config[createBucketIfMissingKey]; val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return bucketProvisioningOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", createBucketIfMissingKey)
+		}
+		opts.enabled = enabled
+	}
+
+	if !opts.enabled {
+		for _, key := range []string{provisionExistingBucketKey, bucketEncryptionKey, blockPublicAccessKey, abortIncompleteUploadsDaysKey, objectLockModeKey, objectLockRetentionDaysKey} {
+			if config[key] != "" {
+				return bucketProvisioningOptions{}, errors.Errorf("%s requires %s", key, createBucketIfMissingKey)
+			}
+		}
+		return opts, nil
+	}
+
+	if readOnly, _ := strconv.ParseBool(config[readOnlyKey]); readOnly {
+		return bucketProvisioningOptions{}, errors.Errorf("%s can't be used with %s", createBucketIfMissingKey, readOnlyKey)
+	}
+	if arn.IsARN(config[bucketKey]) {
+		return bucketProvisioningOptions{}, errors.Errorf("%s can't be used with an access point ARN bucket", createBucketIfMissingKey)
+	}
+	// a missing bucket's region can't be looked up
+	if config[s3URLKey] == "" && config[regionKey] == "" {
+		return bucketProvisioningOptions{}, errors.Errorf("%s requires %s", createBucketIfMissingKey, regionKey)
+	}
+
+	if val := config[provisionExistingBucketKey]; val != "" {
+		provisionExisting, err := strconv.ParseBool(val)
+		if err != nil {
+			return bucketProvisioningOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", provisionExistingBucketKey)
+		}
+		opts.provisionExisting = provisionExisting
+	}
+
+	// by default the bucket is encrypted like the location's objects
+	switch encryption := config[bucketEncryptionKey]; encryption {
+	case "":
+		opts.encryption = s3.ServerSideEncryptionAes256
+		if kmsKeyID := config[kmsKeyIDKey]; kmsKeyID != "" {
+			opts.encryption = s3.ServerSideEncryptionAwsKms
+			opts.kmsKeyID = kmsKeyID
+		}
+	case bucketEncryptionNone:
+	case s3.ServerSideEncryptionAes256:
+		opts.encryption = encryption
+	case s3.ServerSideEncryptionAwsKms:
+		opts.encryption = encryption
+		opts.kmsKeyID = config[kmsKeyIDKey]
+	default:
+		return bucketProvisioningOptions{}, errors.Errorf("invalid %s: %s (expected %s, %s or %s)", bucketEncryptionKey, encryption,
+			s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, bucketEncryptionNone)
+	}
+
+	if val := config[blockPublicAccessKey]; val != "" {
+		block, err := strconv.ParseBool(val)
+		if err != nil {
+			return bucketProvisioningOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", blockPublicAccessKey)
+		}
+		opts.blockPublicAccess = block
+	}
+
+	if val := config[abortIncompleteUploadsDaysKey]; val != "" {
+		days, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return bucketProvisioningOptions{}, errors.Wrapf(err, "could not parse %s (expected int)", abortIncompleteUploadsDaysKey)
+		}
+		if days < 0 {
+			return bucketProvisioningOptions{}, errors.Errorf("invalid %s: must not be negative", abortIncompleteUploadsDaysKey)
+		}
+		opts.abortIncompleteUploadsDays = days
+	}
+
+	switch mode := config[objectLockModeKey]; mode {
+	case "":
+		if config[objectLockRetentionDaysKey] != "" {
+			return bucketProvisioningOptions{}, errors.Errorf("%s requires %s", objectLockRetentionDaysKey, objectLockModeKey)
+		}
+	case s3.ObjectLockRetentionModeGovernance, s3.ObjectLockRetentionModeCompliance:
+		opts.objectLockMode = mode
+
+		days, err := strconv.ParseInt(config[objectLockRetentionDaysKey], 10, 64)
+		if err != nil {
+			return bucketProvisioningOptions{}, errors.Wrapf(err, "could not parse %s (expected int)", objectLockRetentionDaysKey)
+		}
+		if days <= 0 {
+			return bucketProvisioningOptions{}, errors.Errorf("invalid %s: must be positive", objectLockRetentionDaysKey)
+		}
+		opts.objectLockRetentionDays = days
+
+		// objects under retention can't be deleted, so the trash could never
+		// be purged, and deleting every version of an object would fail
+		if trash.retention > 0 {
+			return bucketProvisioningOptions{}, errors.Errorf("%s can't be used with %s", objectLockModeKey, trashRetentionKey)
+		}
+		if deleteAllVersions {
+			return bucketProvisioningOptions{}, errors.Errorf("%s can't be used with %s", objectLockModeKey, deleteAllVersionsKey)
+		}
+	default:
+		return bucketProvisioningOptions{}, errors.Errorf("invalid %s: %s (expected %s or %s)", objectLockModeKey, mode,
+			s3.ObjectLockRetentionModeGovernance, s3.ObjectLockRetentionModeCompliance)
+	}
+
+	return opts, nil
+}
+
+// provisionBucket creates the bucket in region if it doesn't exist, and
+// applies the baseline to it if it was created, if its provisioning tag is
+// still pending from when it was, or if provisionExisting is set. Every step
+// checks the bucket's current configuration first and only changes it if it
+// differs, so it's safe to run on every Init.
+func provisionBucket(client s3BucketInterface, bucket, region, prefix string, opts bucketProvisioningOptions, log logrus.FieldLogger) (err error) {
+	ctx, span := startSpan("ObjectStore.provisionBucket", bucketAttribute.String(bucket))
+	defer func() { endSpan(span, err) }()
+
+	log = log.WithField("bucket", bucket)
+
+	created, err := ensureBucketExists(ctx, client, bucket, region, opts, log.WithField("step", "create bucket"))
+	if err != nil {
+		return errors.WithMessagef(err, "error provisioning bucket %s (create bucket)", bucket)
+	}
+
+	pending := created
+	if created {
+		if err := setProvisioningTag(ctx, client, bucket, provisioningTagPending); err != nil {
+			return errors.WithMessagef(err, "error provisioning bucket %s (provisioning tag)", bucket)
+		}
+	} else {
+		state, err := getProvisioningTag(ctx, client, bucket)
+		if err != nil {
+			return errors.WithMessagef(err, "error provisioning bucket %s (provisioning tag)", bucket)
+		}
+		if pending = state == provisioningTagPending; pending {
+			log.Info("Resuming provisioning of bucket created by the plugin")
+		}
+	}
+	if !pending && !opts.provisionExisting {
+		return nil
+	}
+
+	steps := []struct {
+		name string
+		fn   func(aws.Context, s3BucketInterface, string, string, string, bucketProvisioningOptions, logrus.FieldLogger) error
+	}{
+		{"default encryption", ensureBucketEncryption},
+		{"public access block", ensurePublicAccessBlock},
+		{"incomplete multipart upload lifecycle", ensureAbortIncompleteUploads},
+		{"object lock", ensureObjectLock},
+	}
+
+	for _, step := range steps {
+		if err := step.fn(ctx, client, bucket, region, prefix, opts, log.WithField("step", step.name)); err != nil {
+			return errors.WithMessagef(err, "error provisioning bucket %s (%s)", bucket, step.name)
+		}
+	}
+
+	if pending {
+		if err := setProvisioningTag(ctx, client, bucket, provisioningTagComplete); err != nil {
+			return errors.WithMessagef(err, "error provisioning bucket %s (provisioning tag)", bucket)
+		}
+	}
+
+	return nil
+}
+
+// ensureBucketExists creates the bucket if it doesn't exist, and returns
+// whether it didn't. A bucket that another Init created after this one found
+// it missing counts as created, so that both apply the baseline; every step
+// is safe to apply twice.
+func ensureBucketExists(ctx aws.Context, client s3BucketInterface, bucket, region string, opts bucketProvisioningOptions, log logrus.FieldLogger) (bool, error) {
+	_, err := client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		log.Debug("Bucket exists")
+		return false, nil
+	}
+	if code := errorCode(err); code != notFoundCode && code != s3.ErrCodeNoSuchBucket {
+		return false, errors.WithStack(err)
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	// us-east-1 is the default and can't be given as a location constraint
+	if region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(region)}
+	}
+	if opts.objectLockMode != "" {
+		input.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+
+	// outside us-east-1, S3 rejects creating a bucket the account already
+	// owns, e.g. because another Init created it first
+	if _, err := client.CreateBucketWithContext(ctx, input); err != nil {
+		if errorCode(err) == s3.ErrCodeBucketAlreadyOwnedByYou {
+			log.Debug("Bucket was created by another Init")
+			return true, nil
+		}
+		return false, errors.WithStack(err)
+	}
+
+	log.WithField("region", region).Info("Created bucket")
+	return true, nil
+}
+
+func ensureBucketEncryption(ctx aws.Context, client s3BucketInterface, bucket, _, _ string, opts bucketProvisioningOptions, log logrus.FieldLogger) error {
+	if opts.encryption == "" {
+		return nil
+	}
+
+	current, err := client.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != noSuchEncryptionConfigurationCode {
+		return errors.WithStack(err)
+	}
+	if err == nil && current.ServerSideEncryptionConfiguration != nil {
+		for _, rule := range current.ServerSideEncryptionConfiguration.Rules {
+			if byDefault := rule.ApplyServerSideEncryptionByDefault; byDefault != nil &&
+				aws.StringValue(byDefault.SSEAlgorithm) == opts.encryption &&
+				aws.StringValue(byDefault.KMSMasterKeyID) == opts.kmsKeyID {
+				log.Debug("Bucket default encryption is already configured")
+				return nil
+			}
+		}
+	}
+
+	byDefault := &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(opts.encryption)}
+	if opts.kmsKeyID != "" {
+		byDefault.KMSMasterKeyID = aws.String(opts.kmsKeyID)
+	}
+
+	_, err = client.PutBucketEncryptionWithContext(ctx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucket),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: byDefault}},
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.WithField("algorithm", opts.encryption).Info("Configured bucket default encryption")
+	return nil
+}
+
+func ensurePublicAccessBlock(ctx aws.Context, client s3BucketInterface, bucket, _, _ string, opts bucketProvisioningOptions, log logrus.FieldLogger) error {
+	if !opts.blockPublicAccess {
+		return nil
+	}
+
+	current, err := client.GetPublicAccessBlockWithContext(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != noSuchPublicAccessBlockCode {
+		return errors.WithStack(err)
+	}
+	if err == nil && current.PublicAccessBlockConfiguration != nil {
+		block := current.PublicAccessBlockConfiguration
+		if aws.BoolValue(block.BlockPublicAcls) && aws.BoolValue(block.IgnorePublicAcls) &&
+			aws.BoolValue(block.BlockPublicPolicy) && aws.BoolValue(block.RestrictPublicBuckets) {
+			log.Debug("Bucket public access is already blocked")
+			return nil
+		}
+	}
+
+	_, err = client.PutPublicAccessBlockWithContext(ctx, &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Info("Blocked bucket public access")
+	return nil
+}
+
+// ensureAbortIncompleteUploads adds or updates the lifecycle rule that
+// aborts incomplete multipart uploads under prefix, keeping the bucket's
+// other rules.
+func ensureAbortIncompleteUploads(ctx aws.Context, client s3BucketInterface, bucket, _, prefix string, opts bucketProvisioningOptions, log logrus.FieldLogger) error {
+	if opts.abortIncompleteUploadsDays == 0 {
+		return nil
+	}
+
+	current, err := client.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != noSuchLifecycleConfigurationCode {
+		return errors.WithStack(err)
+	}
+
+	var rules []*s3.LifecycleRule
+	if err == nil {
+		for _, rule := range current.Rules {
+			if aws.StringValue(rule.ID) != abortIncompleteUploadsRuleID {
+				rules = append(rules, rule)
+				continue
+			}
+
+			if aws.StringValue(rule.Status) == s3.ExpirationStatusEnabled &&
+				rule.Filter != nil && aws.StringValue(rule.Filter.Prefix) == prefix &&
+				rule.AbortIncompleteMultipartUpload != nil &&
+				aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation) == opts.abortIncompleteUploadsDays {
+				log.Debug("Bucket lifecycle rule is already configured")
+				return nil
+			}
+		}
+	}
+
+	rules = append(rules, &s3.LifecycleRule{
+		ID:     aws.String(abortIncompleteUploadsRuleID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(prefix)},
+		AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int64(opts.abortIncompleteUploadsDays),
+		},
+	})
+
+	_, err = client.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.WithField("days", opts.abortIncompleteUploadsDays).Info("Configured bucket lifecycle rule to abort incomplete multipart uploads")
+	return nil
+}
+
+// ensureObjectLock enables Object Lock with the default retention. Object
+// Lock requires versioning, so that's enabled first.
+func ensureObjectLock(ctx aws.Context, client s3BucketInterface, bucket, _, _ string, opts bucketProvisioningOptions, log logrus.FieldLogger) error {
+	if opts.objectLockMode == "" {
+		return nil
+	}
+
+	current, err := client.GetObjectLockConfigurationWithContext(ctx, &s3.GetObjectLockConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != noSuchObjectLockConfigurationCode {
+		return errors.WithStack(err)
+	}
+	if err == nil && current.ObjectLockConfiguration != nil {
+		config := current.ObjectLockConfiguration
+		if aws.StringValue(config.ObjectLockEnabled) == s3.ObjectLockEnabledEnabled &&
+			config.Rule != nil && config.Rule.DefaultRetention != nil &&
+			aws.StringValue(config.Rule.DefaultRetention.Mode) == opts.objectLockMode &&
+			aws.Int64Value(config.Rule.DefaultRetention.Days) == opts.objectLockRetentionDays {
+			log.Debug("Bucket object lock is already configured")
+			return nil
+		}
+	}
+
+	versioning, err := client.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		_, err := client.PutBucketVersioningWithContext(ctx, &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		log.Info("Enabled bucket versioning")
+	}
+
+	_, err = client.PutObjectLockConfigurationWithContext(ctx, &s3.PutObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+		ObjectLockConfiguration: &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled),
+			Rule: &s3.ObjectLockRule{
+				DefaultRetention: &s3.DefaultRetention{
+					Mode: aws.String(opts.objectLockMode),
+					Days: aws.Int64(opts.objectLockRetentionDays),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"mode": opts.objectLockMode,
+		"days": opts.objectLockRetentionDays,
+	}).Info("Configured bucket object lock")
+	return nil
+}
+
+// getProvisioningTag returns the value of the bucket's provisioning tag, or
+// an empty string if it has none.
+func getProvisioningTag(ctx aws.Context, client s3BucketInterface, bucket string) (string, error) {
+	res, err := client.GetBucketTaggingWithContext(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if errorCode(err) == noSuchTagSetCode {
+			return "", nil
+		}
+		return "", errors.WithStack(err)
+	}
+
+	for _, tag := range res.TagSet {
+		if aws.StringValue(tag.Key) == provisioningTag {
+			return aws.StringValue(tag.Value), nil
+		}
+	}
+	return "", nil
+}
+
+// setProvisioningTag sets the bucket's provisioning tag to state, keeping
+// its other tags.
+func setProvisioningTag(ctx aws.Context, client s3BucketInterface, bucket, state string) error {
+	res, err := client.GetBucketTaggingWithContext(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != noSuchTagSetCode {
+		return errors.WithStack(err)
+	}
+
+	tags := []*s3.Tag{{Key: aws.String(provisioningTag), Value: aws.String(state)}}
+	if err == nil {
+		for _, tag := range res.TagSet {
+			if aws.StringValue(tag.Key) != provisioningTag {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	_, err = client.PutBucketTaggingWithContext(ctx, &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucket),
+		Tagging: &s3.Tagging{TagSet: tags},
+	})
+	return errors.WithStack(err)
+}
@@ -0,0 +1,425 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockS3Buckets struct {
+	mock.Mock
+}
+
+func (m *mockS3Buckets) HeadBucketWithContext(ctx aws.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.HeadBucketOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) CreateBucketWithContext(ctx aws.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.CreateBucketOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetBucketEncryptionWithContext(ctx aws.Context, input *s3.GetBucketEncryptionInput, opts ...request.Option) (*s3.GetBucketEncryptionOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketEncryptionOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutBucketEncryptionWithContext(ctx aws.Context, input *s3.PutBucketEncryptionInput, opts ...request.Option) (*s3.PutBucketEncryptionOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketEncryptionOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetPublicAccessBlockWithContext(ctx aws.Context, input *s3.GetPublicAccessBlockInput, opts ...request.Option) (*s3.GetPublicAccessBlockOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetPublicAccessBlockOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutPublicAccessBlockWithContext(ctx aws.Context, input *s3.PutPublicAccessBlockInput, opts ...request.Option) (*s3.PutPublicAccessBlockOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutPublicAccessBlockOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetBucketLifecycleConfigurationWithContext(ctx aws.Context, input *s3.GetBucketLifecycleConfigurationInput, opts ...request.Option) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketLifecycleConfigurationOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutBucketLifecycleConfigurationWithContext(ctx aws.Context, input *s3.PutBucketLifecycleConfigurationInput, opts ...request.Option) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketLifecycleConfigurationOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetBucketVersioningWithContext(ctx aws.Context, input *s3.GetBucketVersioningInput, opts ...request.Option) (*s3.GetBucketVersioningOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketVersioningOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutBucketVersioningWithContext(ctx aws.Context, input *s3.PutBucketVersioningInput, opts ...request.Option) (*s3.PutBucketVersioningOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketVersioningOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetObjectLockConfigurationWithContext(ctx aws.Context, input *s3.GetObjectLockConfigurationInput, opts ...request.Option) (*s3.GetObjectLockConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetObjectLockConfigurationOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutObjectLockConfigurationWithContext(ctx aws.Context, input *s3.PutObjectLockConfigurationInput, opts ...request.Option) (*s3.PutObjectLockConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutObjectLockConfigurationOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) GetBucketTaggingWithContext(ctx aws.Context, input *s3.GetBucketTaggingInput, opts ...request.Option) (*s3.GetBucketTaggingOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketTaggingOutput), args.Error(1)
+}
+
+func (m *mockS3Buckets) PutBucketTaggingWithContext(ctx aws.Context, input *s3.PutBucketTaggingInput, opts ...request.Option) (*s3.PutBucketTaggingOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketTaggingOutput), args.Error(1)
+}
+
+// provisioningTagging returns the bucket tagging with the provisioning tag
+// set to state, followed by tags.
+func provisioningTagging(state string, tags ...*s3.Tag) *s3.PutBucketTaggingInput {
+	return &s3.PutBucketTaggingInput{
+		Bucket:  aws.String("bucket"),
+		Tagging: &s3.Tagging{TagSet: append([]*s3.Tag{{Key: aws.String(provisioningTag), Value: aws.String(state)}}, tags...)},
+	}
+}
+
+func TestParseBucketProvisioningOptions(t *testing.T) {
+	tests := []struct {
+		name              string
+		config            map[string]string
+		trash             trashOptions
+		deleteAllVersions bool
+		expected          bucketProvisioningOptions
+		expectedErr       string
+	}{
+		{
+			name:     "disabled",
+			config:   map[string]string{},
+			expected: bucketProvisioningOptions{blockPublicAccess: true, abortIncompleteUploadsDays: 7},
+		},
+		{
+			name:        "baseline without createBucketIfMissing",
+			config:      map[string]string{objectLockModeKey: "GOVERNANCE"},
+			expectedErr: "objectLockMode requires createBucketIfMissing",
+		},
+		{
+			name:        "provisionExistingBucket without createBucketIfMissing",
+			config:      map[string]string{provisionExistingBucketKey: "true"},
+			expectedErr: "provisionExistingBucket requires createBucketIfMissing",
+		},
+		{
+			name:   "defaults",
+			config: map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2"},
+			expected: bucketProvisioningOptions{
+				enabled:                    true,
+				encryption:                 "AES256",
+				blockPublicAccess:          true,
+				abortIncompleteUploadsDays: 7,
+			},
+		},
+		{
+			name: "encrypted with the location's KMS key",
+			config: map[string]string{
+				createBucketIfMissingKey:      "true",
+				provisionExistingBucketKey:    "true",
+				regionKey:                     "us-west-2",
+				kmsKeyIDKey:                   "alias/velero",
+				blockPublicAccessKey:          "false",
+				abortIncompleteUploadsDaysKey: "0",
+				objectLockModeKey:             "COMPLIANCE",
+				objectLockRetentionDaysKey:    "30",
+			},
+			expected: bucketProvisioningOptions{
+				enabled:                 true,
+				provisionExisting:       true,
+				encryption:              "aws:kms",
+				kmsKeyID:                "alias/velero",
+				objectLockMode:          "COMPLIANCE",
+				objectLockRetentionDays: 30,
+			},
+		},
+		{
+			name:        "no region",
+			config:      map[string]string{createBucketIfMissingKey: "true"},
+			expectedErr: "createBucketIfMissing requires region",
+		},
+		{
+			name:        "read-only",
+			config:      map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", readOnlyKey: "true"},
+			expectedErr: "createBucketIfMissing can't be used with readOnly",
+		},
+		{
+			name:        "access point",
+			config:      map[string]string{createBucketIfMissingKey: "true", bucketKey: testAccessPointARN},
+			expectedErr: "createBucketIfMissing can't be used with an access point ARN bucket",
+		},
+		{
+			name:        "invalid encryption",
+			config:      map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", bucketEncryptionKey: "aws:kms:dsse"},
+			expectedErr: "invalid bucketEncryption: aws:kms:dsse (expected AES256, aws:kms or none)",
+		},
+		{
+			name:        "invalid object lock mode",
+			config:      map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", objectLockModeKey: "governance"},
+			expectedErr: "invalid objectLockMode: governance (expected GOVERNANCE or COMPLIANCE)",
+		},
+		{
+			name:        "object lock without retention",
+			config:      map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", objectLockModeKey: "GOVERNANCE", objectLockRetentionDaysKey: "0"},
+			expectedErr: "invalid objectLockRetentionDays: must be positive",
+		},
+		{
+			name:        "object lock with trash purge",
+			config:      map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", objectLockModeKey: "GOVERNANCE", objectLockRetentionDaysKey: "30"},
+			trash:       trashOptions{enabled: true, retention: 24 * time.Hour},
+			expectedErr: "objectLockMode can't be used with trashRetention",
+		},
+		{
+			name:              "object lock with deleteAllVersions",
+			config:            map[string]string{createBucketIfMissingKey: "true", regionKey: "us-west-2", objectLockModeKey: "GOVERNANCE", objectLockRetentionDaysKey: "30"},
+			deleteAllVersions: true,
+			expectedErr:       "objectLockMode can't be used with deleteAllVersions",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseBucketProvisioningOptions(tc.config, tc.trash, tc.deleteAllVersions)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+var testProvisioningOptions = bucketProvisioningOptions{
+	enabled:                    true,
+	encryption:                 "AES256",
+	blockPublicAccess:          true,
+	abortIncompleteUploadsDays: 7,
+	objectLockMode:             "GOVERNANCE",
+	objectLockRetentionDays:    30,
+}
+
+func TestProvisionMissingBucket(t *testing.T) {
+	client := new(mockS3Buckets)
+	bucket := aws.String("bucket")
+
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, awserr.New(notFoundCode, "Not Found", nil))
+	client.On("CreateBucketWithContext", &s3.CreateBucketInput{
+		Bucket:                     bucket,
+		CreateBucketConfiguration:  &s3.CreateBucketConfiguration{LocationConstraint: aws.String("us-west-2")},
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	}).Return(&s3.CreateBucketOutput{}, nil)
+
+	// the bucket is tagged pending until every step succeeded
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{}, awserr.New(noSuchTagSetCode, "", nil))
+	client.On("PutBucketTaggingWithContext", provisioningTagging(provisioningTagPending)).Return(&s3.PutBucketTaggingOutput{}, nil).Once()
+	client.On("PutBucketTaggingWithContext", provisioningTagging(provisioningTagComplete)).Return(&s3.PutBucketTaggingOutput{}, nil).Once()
+
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{}, awserr.New(noSuchEncryptionConfigurationCode, "", nil))
+	client.On("PutBucketEncryptionWithContext", &s3.PutBucketEncryptionInput{
+		Bucket: bucket,
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String("AES256")}}},
+		},
+	}).Return(&s3.PutBucketEncryptionOutput{}, nil)
+
+	client.On("GetPublicAccessBlockWithContext", mock.Anything).Return(&s3.GetPublicAccessBlockOutput{}, awserr.New(noSuchPublicAccessBlockCode, "", nil))
+	client.On("PutPublicAccessBlockWithContext", mock.Anything).Return(&s3.PutPublicAccessBlockOutput{}, nil)
+
+	// existing lifecycle rules are kept
+	otherRule := &s3.LifecycleRule{ID: aws.String("expire-trash"), Status: aws.String("Enabled")}
+	client.On("GetBucketLifecycleConfigurationWithContext", mock.Anything).Return(&s3.GetBucketLifecycleConfigurationOutput{
+		Rules: []*s3.LifecycleRule{otherRule},
+	}, nil)
+	client.On("PutBucketLifecycleConfigurationWithContext", &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: bucket,
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: []*s3.LifecycleRule{
+			otherRule,
+			{
+				ID:                             aws.String(abortIncompleteUploadsRuleID),
+				Status:                         aws.String("Enabled"),
+				Filter:                         &s3.LifecycleRuleFilter{Prefix: aws.String("velero")},
+				AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(7)},
+			},
+		}},
+	}).Return(&s3.PutBucketLifecycleConfigurationOutput{}, nil)
+
+	client.On("GetObjectLockConfigurationWithContext", mock.Anything).Return(&s3.GetObjectLockConfigurationOutput{}, awserr.New(noSuchObjectLockConfigurationCode, "", nil))
+	client.On("GetBucketVersioningWithContext", mock.Anything).Return(&s3.GetBucketVersioningOutput{}, nil)
+	client.On("PutBucketVersioningWithContext", mock.Anything).Return(&s3.PutBucketVersioningOutput{}, nil)
+	client.On("PutObjectLockConfigurationWithContext", mock.Anything).Return(&s3.PutObjectLockConfigurationOutput{}, nil)
+
+	require.NoError(t, provisionBucket(client, "bucket", "us-west-2", "velero", testProvisioningOptions, newLogger()))
+	client.AssertExpectations(t)
+}
+
+func TestProvisionExistingBucket(t *testing.T) {
+	client := new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{
+		TagSet: []*s3.Tag{{Key: aws.String("team"), Value: aws.String("platform")}},
+	}, nil)
+
+	// a bucket the plugin didn't create is left alone
+	require.NoError(t, provisionBucket(client, "bucket", "us-east-1", "", testProvisioningOptions, newLogger()))
+	client.AssertExpectations(t)
+	assert.Len(t, client.Calls, 2)
+
+	// and so is one it finished provisioning
+	client = new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{
+		TagSet: []*s3.Tag{{Key: aws.String(provisioningTag), Value: aws.String(provisioningTagComplete)}},
+	}, nil)
+
+	require.NoError(t, provisionBucket(client, "bucket", "us-east-1", "", testProvisioningOptions, newLogger()))
+	client.AssertExpectations(t)
+	assert.Len(t, client.Calls, 2)
+}
+
+func TestProvisionBucketRetry(t *testing.T) {
+	opts := bucketProvisioningOptions{enabled: true, encryption: "AES256"}
+	teamTag := &s3.Tag{Key: aws.String("team"), Value: aws.String("platform")}
+
+	// creating the bucket succeeds, but applying the baseline fails
+	client := new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, awserr.New(notFoundCode, "Not Found", nil))
+	client.On("CreateBucketWithContext", mock.Anything).Return(&s3.CreateBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{}, awserr.New(noSuchTagSetCode, "", nil))
+	client.On("PutBucketTaggingWithContext", provisioningTagging(provisioningTagPending)).Return(&s3.PutBucketTaggingOutput{}, nil)
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{}, awserr.New(noSuchEncryptionConfigurationCode, "", nil))
+	client.On("PutBucketEncryptionWithContext", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, errors.New("SlowDown"))
+
+	err := provisionBucket(client, "bucket", "us-east-1", "", opts, newLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error provisioning bucket bucket (default encryption): SlowDown")
+	client.AssertExpectations(t)
+
+	// the next Init finds the bucket, which someone tagged meanwhile, still
+	// pending and applies the baseline again
+	client = new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{
+		TagSet: []*s3.Tag{{Key: aws.String(provisioningTag), Value: aws.String(provisioningTagPending)}, teamTag},
+	}, nil)
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{}, awserr.New(noSuchEncryptionConfigurationCode, "", nil))
+	client.On("PutBucketEncryptionWithContext", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, nil)
+	client.On("PutBucketTaggingWithContext", provisioningTagging(provisioningTagComplete, teamTag)).Return(&s3.PutBucketTaggingOutput{}, nil)
+
+	require.NoError(t, provisionBucket(client, "bucket", "us-east-1", "", opts, newLogger()))
+	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "CreateBucketWithContext", mock.Anything)
+}
+
+func TestProvisionConfiguredBucket(t *testing.T) {
+	client := new(mockS3Buckets)
+	opts := testProvisioningOptions
+	opts.provisionExisting = true
+
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{}, awserr.New(noSuchTagSetCode, "", nil))
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String("AES256")}}},
+		},
+	}, nil)
+	client.On("GetPublicAccessBlockWithContext", mock.Anything).Return(&s3.GetPublicAccessBlockOutput{
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	}, nil)
+	client.On("GetBucketLifecycleConfigurationWithContext", mock.Anything).Return(&s3.GetBucketLifecycleConfigurationOutput{
+		Rules: []*s3.LifecycleRule{{
+			ID:                             aws.String(abortIncompleteUploadsRuleID),
+			Status:                         aws.String("Enabled"),
+			Filter:                         &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(7)},
+		}},
+	}, nil)
+	client.On("GetObjectLockConfigurationWithContext", mock.Anything).Return(&s3.GetObjectLockConfigurationOutput{
+		ObjectLockConfiguration: &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String("Enabled"),
+			Rule: &s3.ObjectLockRule{
+				DefaultRetention: &s3.DefaultRetention{Mode: aws.String("GOVERNANCE"), Days: aws.Int64(30)},
+			},
+		},
+	}, nil)
+
+	// nothing is changed, and the bucket isn't tagged
+	require.NoError(t, provisionBucket(client, "bucket", "us-east-1", "", opts, newLogger()))
+	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "PutBucketTaggingWithContext", mock.Anything)
+}
+
+func TestProvisionBucketErrors(t *testing.T) {
+	// another Init created the bucket first, and both apply the baseline
+	client := new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, awserr.New(notFoundCode, "Not Found", nil))
+	client.On("CreateBucketWithContext", &s3.CreateBucketInput{
+		Bucket:                    aws.String("bucket"),
+		CreateBucketConfiguration: &s3.CreateBucketConfiguration{LocationConstraint: aws.String("us-west-2")},
+	}).Return(&s3.CreateBucketOutput{}, awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, "", nil))
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{}, awserr.New(noSuchTagSetCode, "", nil))
+	client.On("PutBucketTaggingWithContext", mock.Anything).Return(&s3.PutBucketTaggingOutput{}, nil)
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{}, awserr.New(noSuchEncryptionConfigurationCode, "", nil))
+	client.On("PutBucketEncryptionWithContext", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, nil)
+
+	require.NoError(t, provisionBucket(client, "bucket", "us-west-2", "", bucketProvisioningOptions{enabled: true, encryption: "AES256"}, newLogger()))
+	client.AssertExpectations(t)
+
+	// a failed step stops provisioning
+	client = new(mockS3Buckets)
+	client.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, awserr.New(notFoundCode, "Not Found", nil))
+	client.On("CreateBucketWithContext", mock.Anything).Return(&s3.CreateBucketOutput{}, nil)
+	client.On("GetBucketTaggingWithContext", mock.Anything).Return(&s3.GetBucketTaggingOutput{}, awserr.New(noSuchTagSetCode, "", nil))
+	client.On("PutBucketTaggingWithContext", mock.Anything).Return(&s3.PutBucketTaggingOutput{}, nil)
+	client.On("GetBucketEncryptionWithContext", mock.Anything).Return(&s3.GetBucketEncryptionOutput{}, awserr.New(noSuchEncryptionConfigurationCode, "", nil))
+	client.On("PutBucketEncryptionWithContext", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, errors.New("AccessDenied"))
+
+	err := provisionBucket(client, "bucket", "us-east-1", "", testProvisioningOptions, newLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error provisioning bucket bucket (default encryption): AccessDenied")
+	client.AssertNotCalled(t, "GetPublicAccessBlockWithContext", mock.Anything)
+	// the bucket is left pending
+	client.AssertCalled(t, "PutBucketTaggingWithContext", provisioningTagging(provisioningTagPending))
+	client.AssertNumberOfCalls(t, "PutBucketTaggingWithContext", 1)
+}
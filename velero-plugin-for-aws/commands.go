@@ -0,0 +1,372 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// configFlag is the flag that takes the backup storage location's config,
+// as comma-separated key=value pairs, e.g. "bucket=velero,region=us-east-1".
+// The pairs are parsed as CSV, so a pair whose value has commas is quoted,
+// e.g. 'bucket=velero,"objectTags=team=a,env=prod"'.
+const configFlag = "config"
+
+// configJSONFlag is the flag that takes the backup storage location's config
+// as a JSON object, e.g. the spec.config of a BackupStorageLocation, so that
+// values don't need to be quoted.
+const configJSONFlag = "config-json"
+
+// command is run by the plugin binary instead of the plugin server when the
+// binary is started with the command's name as its first argument, e.g.
+//
+//	/plugins/velero-plugin-for-aws list-versions --config bucket=velero,region=us-east-1 backups/b1/
+//
+// Commands give operators and external data movers access to what Velero's
+// ObjectStore interface can't ask the plugin for. Each one initializes an
+// ObjectStore from the same config as a backup storage location, and works
+// on the location's bucket. Init doesn't change the bucket for a command, so
+// a command that reads only ever reads.
+type command struct {
+	name string
+	// args describes the command's arguments in its usage.
+	args  string
+	short string
+	// nargs is the number of arguments the command takes. If variadic is
+	// set, it's the least number, and the last one can be repeated.
+	nargs    int
+	variadic bool
+	flags    *pflag.FlagSet
+	run      func(o *ObjectStore, bucket string, args []string, out io.Writer) error
+}
+
+// commands returns every command the plugin binary runs.
+func commands() []*command {
+	return []*command{
+		newListVersionsCommand(),
+		newGetVersionCommand(),
+		newDeletePrefixCommand(),
+		newListObjectsCommand(),
+		newGetLabelsCommand(),
+		newPresignGetCommand(),
+		newPresignPutCommand(),
+		newPresignHeadCommand(),
+		newCreateMultipartUploadCommand(),
+		newPresignUploadPartCommand(),
+		newCompleteMultipartUploadCommand(),
+	}
+}
+
+// lookupCommand returns the command that args, the plugin binary's arguments
+// without the program name, start with, or nil if they don't start with one
+// and the plugin server should be run.
+func lookupCommand(args []string) *command {
+	if len(args) == 0 {
+		return nil
+	}
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func newCommand(name, args, short string, nargs int) *command {
+	cmd := &command{
+		name:  name,
+		args:  args,
+		short: short,
+		nargs: nargs,
+		flags: pflag.NewFlagSet(name, pflag.ContinueOnError),
+	}
+	cmd.flags.StringToString(configFlag, nil, `the backup storage location's config, as key=value pairs. A pair whose value has commas must be quoted, e.g. 'bucket=velero,"objectTags=team=a,env=prod"'`)
+	cmd.flags.String(configJSONFlag, "", "the backup storage location's config, as a JSON object, e.g. the location's spec.config. Keys in --"+configFlag+" override it")
+	return cmd
+}
+
+func (c *command) usage() string {
+	return fmt.Sprintf("Usage: velero-plugin-for-aws %s {--%s bucket=<bucket>,... | --%s '{\"bucket\": \"<bucket>\", ...}'} %s\n\n%s\n\nFlags:\n%s",
+		c.name, configFlag, configJSONFlag, c.args, c.short, c.flags.FlagUsages())
+}
+
+// execute parses the command's flags and arguments from args, which don't
+// include the command's name, and runs it.
+func (c *command) execute(args []string, out io.Writer, log logrus.FieldLogger) error {
+	if err := c.flags.Parse(args); err != nil {
+		return errors.Errorf("%v\n%s", err, c.usage())
+	}
+	if c.variadic && c.flags.NArg() < c.nargs {
+		return errors.Errorf("%s takes at least %d arguments, got %d\n%s", c.name, c.nargs, c.flags.NArg(), c.usage())
+	}
+	if !c.variadic && c.flags.NArg() != c.nargs {
+		return errors.Errorf("%s takes %d arguments, got %d\n%s", c.name, c.nargs, c.flags.NArg(), c.usage())
+	}
+
+	config, err := c.config()
+	if err != nil {
+		return err
+	}
+	if config[bucketKey] == "" {
+		return errors.Errorf("--%s or --%s must include %s\n%s", configFlag, configJSONFlag, bucketKey, c.usage())
+	}
+
+	o := newCommandObjectStore(log)
+	if err := o.Init(config); err != nil {
+		return err
+	}
+
+	return c.run(o, config[bucketKey], c.flags.Args(), out)
+}
+
+// config returns the backup storage location's config from the command's
+// flags.
+func (c *command) config() (map[string]string, error) {
+	config := map[string]string{}
+	if val, _ := c.flags.GetString(configJSONFlag); val != "" {
+		if err := json.Unmarshal([]byte(val), &config); err != nil {
+			return nil, errors.Wrapf(err, "could not parse --%s (expected a JSON object of strings)", configJSONFlag)
+		}
+	}
+
+	pairs, err := c.flags.GetStringToString(configFlag)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for key, val := range pairs {
+		config[key] = val
+	}
+
+	return config, nil
+}
+
+// writeJSON writes v to out as indented JSON.
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return errors.WithStack(encoder.Encode(v))
+}
+
+func newListVersionsCommand() *command {
+	cmd := newCommand("list-versions", "<prefix>", "Lists the versions of the objects under prefix that can still be read, e.g. those of a deleted backup.", 1)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		versions, err := o.listRecoverableVersions(bucket, args[0])
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, versions)
+	}
+	return cmd
+}
+
+func newGetVersionCommand() *command {
+	cmd := newCommand("get-version", "<key> <version ID>", "Writes the content of a version of the object at key to stdout.", 2)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		body, err := o.getObjectVersion(bucket, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		_, err = io.Copy(out, body)
+		return errors.Wrapf(err, "error reading version %s of object %s", args[1], args[0])
+	}
+	return cmd
+}
+
+func newDeletePrefixCommand() *command {
+	cmd := newCommand("delete-prefix", "<prefix>", "Deletes every object under prefix, up to 1000 objects per request.", 1)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		return o.deletePrefix(bucket, args[0])
+	}
+	return cmd
+}
+
+func newListObjectsCommand() *command {
+	cmd := newCommand("list-objects", "<prefix>", "Lists the objects under prefix, with their size and last modified time, as one JSON object per line. Objects are written as they're listed, so any number of them can be listed in bounded memory.", 1)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		encoder := json.NewEncoder(out)
+		return o.walkObjects(aws.BackgroundContext(), bucket, args[0], false, func(obj objectInfo) error {
+			return errors.WithStack(encoder.Encode(obj))
+		})
+	}
+	return cmd
+}
+
+func newGetLabelsCommand() *command {
+	cmd := newCommand("get-labels", "<key>", "Prints the tags and user metadata of the object at key as JSON.", 1)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		labels, err := o.getObjectLabels(bucket, args[0])
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, labels)
+	}
+	return cmd
+}
+
+// defaultPresignTTL is how long the URLs the presign commands create are
+// valid for, unless --ttl is set.
+const defaultPresignTTL = time.Hour
+
+// endpointFlag is the presign commands' flag that names the public endpoint,
+// in the location's publicEndpoints, that the URL is created for.
+const endpointFlag = "endpoint"
+
+// addPresignFlags adds the flags that every presign command takes.
+func addPresignFlags(cmd *command) (ttl *time.Duration, endpoint *string) {
+	ttl = cmd.flags.Duration("ttl", defaultPresignTTL, "how long the URL is valid for")
+	endpoint = cmd.flags.String(endpointFlag, "", "the name of the public endpoint in publicEndpoints that the URL is for (defaults to defaultPublicEndpoint)")
+	return ttl, endpoint
+}
+
+// forEndpoint returns o, or if name is set, a copy of o that signs URLs for
+// the public endpoint with that name.
+func forEndpoint(o *ObjectStore, name string) (*ObjectStore, error) {
+	if name == "" {
+		return o, nil
+	}
+	return o.withPublicEndpoint(name)
+}
+
+func newPresignGetCommand() *command {
+	cmd := newCommand("presign-get", "<key>", "Creates a URL that downloads the object at key with a GET request, like the URLs Velero creates, and prints it as JSON.", 1)
+	ttl, endpoint := addPresignFlags(cmd)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		if *endpoint != "" && o.signedURLs.cloudFront != nil {
+			return errors.Errorf("--%s can't be used with %s", endpointFlag, cloudFrontURLKey)
+		}
+		o, err := forEndpoint(o, *endpoint)
+		if err != nil {
+			return err
+		}
+
+		url, err := o.CreateSignedURL(bucket, args[0], *ttl)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, &presignedRequest{Method: http.MethodGet, URL: url})
+	}
+	return cmd
+}
+
+func newPresignPutCommand() *command {
+	cmd := newCommand("presign-put", "<key>", "Creates a URL that uploads the object at key with a PUT request, and prints it with the method and headers to send, as JSON. The object is encrypted and labeled like the objects Velero uploads.", 1)
+	ttl, endpoint := addPresignFlags(cmd)
+	contentType := cmd.flags.String("content-type", "", "the Content-Type the upload must send")
+	contentMD5 := cmd.flags.String("content-md5", "", "the base64-encoded MD5 of the content, which the upload must send and match")
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		o, err := forEndpoint(o, *endpoint)
+		if err != nil {
+			return err
+		}
+
+		presigned, err := o.createSignedPutURL(bucket, args[0], *ttl, *contentType, *contentMD5)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, presigned)
+	}
+	return cmd
+}
+
+func newPresignHeadCommand() *command {
+	cmd := newCommand("presign-head", "<key>", "Creates a URL that reads the metadata of the object at key with a HEAD request, and prints it as JSON.", 1)
+	ttl, endpoint := addPresignFlags(cmd)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		o, err := forEndpoint(o, *endpoint)
+		if err != nil {
+			return err
+		}
+
+		presigned, err := o.createSignedHeadURL(bucket, args[0], *ttl)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, presigned)
+	}
+	return cmd
+}
+
+func newCreateMultipartUploadCommand() *command {
+	cmd := newCommand("create-multipart-upload", "<key>", "Starts a multipart upload of the object at key and prints its upload ID. Its parts are uploaded to URLs from presign-upload-part.", 1)
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		uploadID, err := o.createMultipartUpload(bucket, args[0])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, uploadID)
+		return errors.WithStack(err)
+	}
+	return cmd
+}
+
+func newPresignUploadPartCommand() *command {
+	cmd := newCommand("presign-upload-part", "<key> <upload ID> <part number>", "Creates a URL that uploads a part, numbered from 1 to 10000, of a multipart upload with a PUT request, and prints it as JSON.", 3)
+	ttl, endpoint := addPresignFlags(cmd)
+	contentMD5 := cmd.flags.String("content-md5", "", "the base64-encoded MD5 of the part, which the upload must send and match")
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		partNumber, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "could not parse part number %s (expected int)", args[2])
+		}
+		if o, err = forEndpoint(o, *endpoint); err != nil {
+			return err
+		}
+
+		presigned, err := o.createSignedUploadPartURL(bucket, args[0], args[1], partNumber, *ttl, *contentMD5)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, presigned)
+	}
+	return cmd
+}
+
+func newCompleteMultipartUploadCommand() *command {
+	cmd := newCommand("complete-multipart-upload", "<key> <upload ID> <part number>=<ETag>...", "Completes a multipart upload from the ETag S3 returned for each of its parts. If it can't be completed, it's aborted.", 3)
+	cmd.variadic = true
+	cmd.run = func(o *ObjectStore, bucket string, args []string, out io.Writer) error {
+		var parts []*s3.CompletedPart
+		for _, arg := range args[2:] {
+			part := strings.SplitN(arg, "=", 2)
+			if len(part) != 2 || part[1] == "" {
+				return errors.Errorf("invalid part %s (expected <part number>=<ETag>)", arg)
+			}
+			partNumber, err := strconv.ParseInt(part[0], 10, 64)
+			if err != nil {
+				return errors.Errorf("invalid part %s (expected <part number>=<ETag>)", arg)
+			}
+			parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: aws.String(part[1])})
+		}
+
+		return o.completeMultipartUpload(bucket, args[0], args[1], parts)
+	}
+	return cmd
+}
@@ -0,0 +1,315 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// respond returns a handler that answers every request with response, and
+// stores the last request it received in received.
+func respond(response string, received **http.Request) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*received = r
+		_, _ = w.Write([]byte(response))
+	}
+}
+
+// runCommand runs the command args start with against an S3 server that
+// handles requests with handler.
+func runCommand(t *testing.T, handler http.HandlerFunc, args ...string) (string, error) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := lookupCommand(args)
+	require.NotNil(t, cmd)
+
+	args = append(args[1:],
+		"--config", "bucket=bucket,region=us-east-1,s3ForcePathStyle=true",
+		"--config", "s3Url="+server.URL,
+		"--config", "credentialsFile="+writeCredentialsFile(t, dir),
+	)
+
+	var out bytes.Buffer
+	err = cmd.execute(args, &out, newLogger())
+	return out.String(), err
+}
+
+func TestLookupCommand(t *testing.T) {
+	assert.Nil(t, lookupCommand(nil))
+	// the plugin server is run with flags
+	assert.Nil(t, lookupCommand([]string{"--log-level", "info"}))
+	assert.Equal(t, "list-versions", lookupCommand([]string{"list-versions", "backups/b1/"}).name)
+}
+
+func TestCommandArgs(t *testing.T) {
+	err := lookupCommand([]string{"get-version"}).execute([]string{"--config", "bucket=bucket", "backups/b1/b1.tar.gz"}, ioutil.Discard, newLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "get-version takes 2 arguments, got 1")
+
+	err = lookupCommand([]string{"get-version"}).execute([]string{"--config", "region=us-east-1", "backups/b1/b1.tar.gz", "v1"}, ioutil.Discard, newLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--config or --config-json must include bucket")
+}
+
+func TestCommandConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "pairs",
+			args:     []string{"--config", "bucket=velero,region=us-east-1", "--config", "prefix=cluster-1"},
+			expected: map[string]string{"bucket": "velero", "region": "us-east-1", "prefix": "cluster-1"},
+		},
+		{
+			name:     "quoted pair with commas",
+			args:     []string{"--config", `bucket=velero,"objectTags=team=a,env=prod"`},
+			expected: map[string]string{"bucket": "velero", "objectTags": "team=a,env=prod"},
+		},
+		{
+			name: "JSON",
+			args: []string{"--config-json", `{"bucket": "velero", "objectTags": "team=a,env=prod", "region": "us-east-1"}`, "--config", "region=us-west-2"},
+			expected: map[string]string{
+				"bucket":     "velero",
+				"objectTags": "team=a,env=prod",
+				"region":     "us-west-2",
+			},
+		},
+		{
+			name:        "invalid JSON",
+			args:        []string{"--config-json", `{"bucket": "velero", "listConcurrency": 4}`},
+			expectedErr: "could not parse --config-json (expected a JSON object of strings)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := lookupCommand([]string{"list-objects"})
+			require.NoError(t, cmd.flags.Parse(tc.args))
+
+			config, err := cmd.config()
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, config)
+		})
+	}
+}
+
+func TestReadCommandsDontWrite(t *testing.T) {
+	var writes []string
+	_, err := runCommand(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writes = append(writes, r.Method+" "+r.URL.String())
+		}
+		_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+	}, "list-objects", "--config", "prefix=read-only-command,validateOnInit=true,softDelete=true,trashRetention=1h,createBucketIfMissing=true,provisionExistingBucket=true", "backups/")
+	require.NoError(t, err)
+
+	assert.Empty(t, writes)
+
+	trashPurgesLock.Lock()
+	defer trashPurgesLock.Unlock()
+	assert.NotContains(t, trashPurges, "bucket/read-only-command")
+}
+
+func TestListVersionsCommand(t *testing.T) {
+	var req *http.Request
+	out, err := runCommand(t, respond(`<ListVersionsResult>
+  <Version><Key>backups/b1/b1.tar.gz</Key><VersionId>v1</VersionId><IsLatest>true</IsLatest><LastModified>2020-05-01T00:00:00.000Z</LastModified><Size>10</Size></Version>
+</ListVersionsResult>`, &req), "list-versions", "backups/b1/")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/bucket", req.URL.Path)
+	assert.Equal(t, "backups/b1/", req.URL.Query().Get("prefix"))
+	assert.JSONEq(t, `[{"key": "backups/b1/b1.tar.gz", "versionId": "v1", "lastModified": "2020-05-01T00:00:00Z", "size": 10, "isLatest": true}]`, out)
+}
+
+func TestGetVersionCommand(t *testing.T) {
+	var req *http.Request
+	out, err := runCommand(t, respond("content", &req), "get-version", "backups/b1/b1.tar.gz", "v1")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/bucket/backups/b1/b1.tar.gz", req.URL.Path)
+	assert.Equal(t, "v1", req.URL.Query().Get("versionId"))
+	assert.Equal(t, "content", out)
+}
+
+func TestDeletePrefixCommand(t *testing.T) {
+	var deleted []string
+	_, err := runCommand(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`<ListBucketResult>
+  <Contents><Key>repos/ns1/data/a</Key></Contents>
+  <Contents><Key>repos/ns1/data/b</Key></Contents>
+</ListBucketResult>`))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		deleted = append(deleted, string(body))
+		_, _ = w.Write([]byte(`<DeleteResult></DeleteResult>`))
+	}, "delete-prefix", "repos/ns1/")
+	require.NoError(t, err)
+
+	// both objects are deleted with a single request
+	require.Len(t, deleted, 1)
+	assert.Contains(t, deleted[0], "<Key>repos/ns1/data/a</Key>")
+	assert.Contains(t, deleted[0], "<Key>repos/ns1/data/b</Key>")
+}
+
+func TestListObjectsCommand(t *testing.T) {
+	var req *http.Request
+	out, err := runCommand(t, respond(`<ListBucketResult>
+  <Contents><Key>repos/ns1/data/a</Key><Size>10</Size><LastModified>2020-05-01T00:00:00.000Z</LastModified></Contents>
+  <Contents><Key>repos/ns1/data/b</Key><Size>20</Size><LastModified>2020-05-02T00:00:00.000Z</LastModified></Contents>
+</ListBucketResult>`, &req), "list-objects", "repos/ns1/")
+	require.NoError(t, err)
+
+	assert.Equal(t, "repos/ns1/", req.URL.Query().Get("prefix"))
+	assert.Equal(t, `{"key":"repos/ns1/data/a","size":10,"lastModified":"2020-05-01T00:00:00Z"}
+{"key":"repos/ns1/data/b","size":20,"lastModified":"2020-05-02T00:00:00Z"}
+`, out)
+}
+
+func TestGetLabelsCommand(t *testing.T) {
+	out, err := runCommand(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["tagging"]; ok {
+			_, _ = w.Write([]byte(`<Tagging><TagSet><Tag><Key>velero.io/backup-name</Key><Value>b1</Value></Tag></TagSet></Tagging>`))
+			return
+		}
+		w.Header().Set("x-amz-meta-team", "platform")
+	}, "get-labels", "backups/b1/b1.tar.gz")
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"tags": {"velero.io/backup-name": "b1"}, "metadata": {"team": "platform"}}`, out)
+}
+
+func TestPresignGetCommand(t *testing.T) {
+	endpoints := `{"publicEndpoints": ` + strconv.Quote(testPublicEndpoints) + `, "defaultPublicEndpoint": "vpc"}`
+
+	tests := []struct {
+		name         string
+		args         []string
+		expectedHost string
+		expectedErr  string
+	}{
+		{
+			name:         "default endpoint",
+			args:         []string{"--config-json", endpoints},
+			expectedHost: "s3.internal:9000",
+		},
+		{
+			name:         "named endpoint",
+			args:         []string{"--config-json", endpoints, "--endpoint", "corp"},
+			expectedHost: "bucket.s3.corp.example.com",
+		},
+		{
+			name:        "unknown endpoint",
+			args:        []string{"--config-json", endpoints, "--endpoint", "mars"},
+			expectedErr: `no public endpoint named "mars" in publicEndpoints`,
+		},
+		{
+			name:        "endpoint without publicEndpoints",
+			args:        []string{"--endpoint", "vpc"},
+			expectedErr: `no public endpoint named "vpc" in publicEndpoints`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var req *http.Request
+			out, err := runCommand(t, respond("", &req), append([]string{"presign-get", "--ttl", "5m", "backups/b1/b1-logs.gz"}, tc.args...)...)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Nil(t, req)
+
+			var presigned presignedRequest
+			require.NoError(t, json.Unmarshal([]byte(out), &presigned))
+			assert.Equal(t, http.MethodGet, presigned.Method)
+
+			u, err := url.Parse(presigned.URL)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, u.Host)
+			assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+		})
+	}
+}
+
+func TestPresignPutCommand(t *testing.T) {
+	var req *http.Request
+	out, err := runCommand(t, respond("", &req), "presign-put", "--ttl", "5m", "--content-type", "application/octet-stream", "restic/data/00")
+	require.NoError(t, err)
+
+	// presigning doesn't send any requests
+	assert.Nil(t, req)
+
+	var presigned presignedRequest
+	require.NoError(t, json.Unmarshal([]byte(out), &presigned))
+	assert.Equal(t, http.MethodPut, presigned.Method)
+	assert.Equal(t, "application/octet-stream", presigned.Header.Get("Content-Type"))
+
+	u, err := url.Parse(presigned.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/bucket/restic/data/00", u.Path)
+	assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+}
+
+func TestCompleteMultipartUploadCommand(t *testing.T) {
+	var (
+		req  *http.Request
+		body []byte
+	)
+	_, err := runCommand(t, func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`))
+	}, "complete-multipart-upload", "restic/data/00", "upload-1", "1=etag-1", "2=etag-2")
+	require.NoError(t, err)
+
+	assert.Equal(t, "upload-1", req.URL.Query().Get("uploadId"))
+	assert.Contains(t, string(body), "<ETag>etag-1</ETag>")
+	assert.Contains(t, string(body), "<PartNumber>2</PartNumber>")
+
+	_, err = runCommand(t, respond("", &req), "complete-multipart-upload", "restic/data/00", "upload-1", "etag-1")
+	assert.EqualError(t, err, "invalid part etag-1 (expected <part number>=<ETag>)")
+}
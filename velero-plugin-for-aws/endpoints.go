@@ -0,0 +1,130 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/pkg/errors"
+)
+
+const (
+	useAccelerateKey = "useAccelerate"
+	useDualStackKey  = "useDualStack"
+	useFIPSKey       = "useFIPS"
+)
+
+// endpointOptions select which of the AWS S3 endpoints of the location's
+// region requests are sent to. They only apply to AWS, not to an s3Url.
+type endpointOptions struct {
+	// accelerate sends requests through S3 Transfer Acceleration, which
+	// must be enabled on the bucket.
+	accelerate bool
+	// dualStack sends requests to endpoints that support IPv6 as well as
+	// IPv4.
+	dualStack bool
+	// fips sends requests to FIPS 140-2 validated endpoints.
+	fips bool
+}
+
+func parseEndpointOptions(config map[string]string) (endpointOptions, error) {
+	var opts endpointOptions
+
+	for _, option := range []struct {
+		key   string
+		value *bool
+	}{
+		{useAccelerateKey, &opts.accelerate},
+		{useDualStackKey, &opts.dualStack},
+		{useFIPSKey, &opts.fips},
+	} {
+		val := config[option.key]
+		if val == "" {
+			continue
+		}
+
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return endpointOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", option.key)
+		}
+		if enabled && config[s3URLKey] != "" {
+			return endpointOptions{}, errors.Errorf("%s can't be used with %s", option.key, s3URLKey)
+		}
+		*option.value = enabled
+	}
+
+	if opts.accelerate {
+		// there are no FIPS endpoints for S3 Transfer Acceleration, and it
+		// always puts the bucket in the host name
+		if opts.fips {
+			return endpointOptions{}, errors.Errorf("%s can't be used with %s", useAccelerateKey, useFIPSKey)
+		}
+		if forcePathStyle, _ := strconv.ParseBool(config[s3ForcePathStyleKey]); forcePathStyle {
+			return endpointOptions{}, errors.Errorf("%s can't be used with %s", useAccelerateKey, s3ForcePathStyleKey)
+		}
+	}
+
+	// otherwise the bucket's region would be looked up through an endpoint
+	// that isn't FIPS validated
+	if opts.fips && config[regionKey] == "" {
+		return endpointOptions{}, errors.Errorf("%s requires %s", useFIPSKey, regionKey)
+	}
+
+	return opts, nil
+}
+
+// apply configures awsConfig to send requests to the selected endpoints.
+func (opts endpointOptions) apply(awsConfig *aws.Config) {
+	if opts.accelerate {
+		awsConfig.WithS3UseAccelerate(true)
+	}
+	if opts.dualStack {
+		awsConfig.WithUseDualStack(true)
+	}
+	if opts.fips {
+		awsConfig.WithEndpointResolver(fipsEndpointResolver(opts.dualStack))
+	}
+}
+
+// fipsEndpointResolver resolves S3 to the region's FIPS endpoint, which the
+// SDK's endpoint model doesn't include for most regions.
+func fipsEndpointResolver(dualStack bool) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if service != endpoints.S3ServiceID {
+			return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+		}
+
+		host := "s3-fips." + region + ".amazonaws.com"
+		if dualStack {
+			host = "s3-fips.dualstack." + region + ".amazonaws.com"
+		}
+
+		resolved := endpoints.ResolvedEndpoint{
+			URL:           fmt.Sprintf("https://%s", host),
+			SigningRegion: region,
+			SigningMethod: "s3v4",
+		}
+		// the SDK checks that access point ARNs are in the client's partition
+		if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+			resolved.PartitionID = partition.ID()
+		}
+		return resolved, nil
+	})
+}
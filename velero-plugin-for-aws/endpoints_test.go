@@ -0,0 +1,157 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpointOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		expected    endpointOptions
+		expectedErr string
+	}{
+		{
+			name:   "defaults",
+			config: map[string]string{},
+		},
+		{
+			name:     "accelerate and dual-stack",
+			config:   map[string]string{useAccelerateKey: "true", useDualStackKey: "true"},
+			expected: endpointOptions{accelerate: true, dualStack: true},
+		},
+		{
+			name:     "FIPS",
+			config:   map[string]string{useFIPSKey: "true", regionKey: "us-east-2"},
+			expected: endpointOptions{fips: true},
+		},
+		{
+			name:        "invalid bool",
+			config:      map[string]string{useDualStackKey: "ipv6"},
+			expectedErr: "could not parse useDualStack (expected bool)",
+		},
+		{
+			name:        "with s3Url",
+			config:      map[string]string{useAccelerateKey: "true", s3URLKey: "http://minio:9000"},
+			expectedErr: "useAccelerate can't be used with s3Url",
+		},
+		{
+			name:   "disabled with s3Url",
+			config: map[string]string{useFIPSKey: "false", s3URLKey: "http://minio:9000"},
+		},
+		{
+			name:        "accelerate and FIPS",
+			config:      map[string]string{useAccelerateKey: "true", useFIPSKey: "true", regionKey: "us-east-2"},
+			expectedErr: "useAccelerate can't be used with useFIPS",
+		},
+		{
+			name:        "accelerate and path style",
+			config:      map[string]string{useAccelerateKey: "true", s3ForcePathStyleKey: "true"},
+			expectedErr: "useAccelerate can't be used with s3ForcePathStyle",
+		},
+		{
+			name:        "FIPS without region",
+			config:      map[string]string{useFIPSKey: "true"},
+			expectedErr: "useFIPS requires region",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseEndpointOptions(tc.config)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestInitEndpointOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name         string
+		config       map[string]string
+		expectedHost string
+	}{
+		{
+			name:         "default",
+			config:       map[string]string{},
+			expectedHost: "bucket.s3.us-east-2.amazonaws.com",
+		},
+		{
+			name:         "accelerate",
+			config:       map[string]string{useAccelerateKey: "true"},
+			expectedHost: "bucket.s3-accelerate.amazonaws.com",
+		},
+		{
+			name:         "dual-stack",
+			config:       map[string]string{useDualStackKey: "true"},
+			expectedHost: "bucket.s3.dualstack.us-east-2.amazonaws.com",
+		},
+		{
+			name:         "accelerate and dual-stack",
+			config:       map[string]string{useAccelerateKey: "true", useDualStackKey: "true"},
+			expectedHost: "bucket.s3-accelerate.dualstack.amazonaws.com",
+		},
+		{
+			name:         "FIPS",
+			config:       map[string]string{useFIPSKey: "true"},
+			expectedHost: "bucket.s3-fips.us-east-2.amazonaws.com",
+		},
+		{
+			name:         "FIPS and dual-stack",
+			config:       map[string]string{useFIPSKey: "true", useDualStackKey: "true"},
+			expectedHost: "bucket.s3-fips.dualstack.us-east-2.amazonaws.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config[bucketKey] = "bucket"
+			tc.config[regionKey] = "us-east-2"
+			tc.config[credentialsFileKey] = writeCredentialsFile(t, dir)
+
+			o := newObjectStore(newLogger())
+			require.NoError(t, o.Init(tc.config))
+
+			// signed URLs show which endpoint the client sends requests to
+			signed, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+			require.NoError(t, err)
+
+			u, err := url.Parse(signed)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, u.Host)
+			assert.Equal(t, "https", u.Scheme)
+		})
+	}
+}
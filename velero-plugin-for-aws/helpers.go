@@ -17,39 +17,190 @@ limitations under the License.
 package main
 
 import (
-	"context"
+	"fmt"
+	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 )
 
+const bucketRegionHeader = "X-Amz-Bucket-Region"
+
+// maxRegionHintsPerPartition bounds how many regions are probed within a
+// partition when earlier probes fail for reasons other than the bucket
+// not existing, e.g. because a region is opt-in and not enabled.
+const maxRegionHintsPerPartition = 3
+
+// preferredRegionHints are the regions probed within their partition, in
+// order. They're enabled for every account, unlike opt-in regions such as
+// af-south-1, which a probe from an account without them always fails in.
+var preferredRegionHints = map[string][]string{
+	endpoints.AwsPartitionID:      {endpoints.UsEast1RegionID, endpoints.UsWest2RegionID, endpoints.EuWest1RegionID},
+	endpoints.AwsCnPartitionID:    {endpoints.CnNorth1RegionID, endpoints.CnNorthwest1RegionID},
+	endpoints.AwsUsGovPartitionID: {endpoints.UsGovWest1RegionID, endpoints.UsGovEast1RegionID},
+}
+
+// bucketRegions caches discovered bucket regions for the life of the
+// process, since a bucket can't move between regions.
+var (
+	bucketRegionsLock sync.Mutex
+	bucketRegions     = map[string]string{}
+)
+
 // GetBucketRegion returns the AWS region that a bucket is in, or an error
-// if the region cannot be determined.
-func GetBucketRegion(bucket string) (string, error) {
-	var region string
+// describing why the region cannot be determined. The lookup is made with
+// the given session options, so it uses the same credentials, CA bundle
+// and proxy settings as the backup storage location.
+func GetBucketRegion(bucket string, options session.Options) (string, error) {
+	// an access point's region is part of its ARN
+	if arn.IsARN(bucket) {
+		accessPoint, err := parseAccessPointARN(bucket)
+		if err != nil {
+			return "", err
+		}
+		return accessPoint.Region, nil
+	}
+
+	bucketRegionsLock.Lock()
+	region, ok := bucketRegions[bucket]
+	bucketRegionsLock.Unlock()
+	if ok {
+		return region, nil
+	}
 
-	session, err := session.NewSession()
+	sess, err := getSession(options)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", errors.Wrap(err, "unable to determine bucket's region")
 	}
 
+	region, err = findBucketRegion(func(regionHint string) (string, error) {
+		return headBucketRegion(sess, bucket, regionHint)
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to determine region of bucket %s", bucket)
+	}
+
+	bucketRegionsLock.Lock()
+	bucketRegions[bucket] = region
+	bucketRegionsLock.Unlock()
+
+	return region, nil
+}
+
+// findBucketRegion probes each partition with up to maxRegionHintsPerPartition
+// region hints until one reports the bucket's region. If none does, the
+// returned error lists why each probe failed.
+func findBucketRegion(probe func(regionHint string) (string, error)) (string, error) {
+	var failures []string
+
 	for _, partition := range endpoints.DefaultPartitions() {
-		for regionHint := range partition.Regions() {
-			region, _ = s3manager.GetBucketRegion(context.Background(), session, bucket, regionHint)
+		for _, regionHint := range regionHints(partition) {
+			region, err := probe(regionHint)
+			if err == nil && region != "" {
+				return region, nil
+			}
+
+			reason, definitive := describeBucketRegionError(err)
+			failures = append(failures, fmt.Sprintf("%s: %s", regionHint, reason))
+
+			// bucket names are unique within a partition, so other
+			// regions in it will give the same answer
+			if definitive {
+				break
+			}
+		}
+	}
 
-			// we only need to try a single region hint per partition, so break after the first
-			break
+	return "", errors.New(strings.Join(failures, "; "))
+}
+
+// regionHints returns the regions of a partition to probe, in order. A
+// partition without preferred regions has no opt-in regions, so any of its
+// regions can be probed.
+func regionHints(partition endpoints.Partition) []string {
+	hints, ok := preferredRegionHints[partition.ID()]
+	if !ok {
+		for id := range partition.Regions() {
+			hints = append(hints, id)
+		}
+		sort.Strings(hints)
+	}
+
+	if len(hints) > maxRegionHintsPerPartition {
+		hints = hints[:maxRegionHintsPerPartition]
+	}
+
+	return hints
+}
+
+// describeBucketRegionError returns a human-readable reason for a failed
+// region probe, and whether the failure would be the same for any other
+// region in the partition.
+func describeBucketRegionError(err error) (string, bool) {
+	if err == nil {
+		return "no region reported", false
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case notFoundCode, s3.ErrCodeNoSuchBucket:
+			return "bucket not found", true
+		case "Forbidden", "AccessDenied":
+			return "access denied", true
+		case request.ErrCodeRequestError, request.ErrCodeResponseTimeout:
+			return fmt.Sprintf("network error: %v", aerr.OrigErr()), false
 		}
+	}
+
+	return err.Error(), false
+}
+
+// headBucketRegion returns the region S3 reports for a bucket when asked via
+// the given region's endpoint. S3 includes the bucket's region in HeadBucket
+// responses even when the request is redirected or rejected because it was
+// sent to, or signed for, the wrong region.
+func headBucketRegion(sess *session.Session, bucket, regionHint string) (string, error) {
+	svc := s3.New(sess, aws.NewConfig().WithRegion(regionHint).WithS3ForcePathStyle(true))
+
+	req, _ := svc.HeadBucketRequest(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+
+	// S3 returns a 301 without a Location header for requests sent to the
+	// wrong region, which Go's HTTP client would otherwise fail on.
+	req.DisableFollowRedirects = true
 
-		if region != "" {
-			return region, nil
+	var region string
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		if r.HTTPResponse == nil {
+			return
+		}
+
+		region = r.HTTPResponse.Header.Get(bucketRegionHeader)
+		if region == "" {
+			return
 		}
+
+		r.HTTPResponse.StatusCode = http.StatusOK
+		r.HTTPResponse.Status = http.StatusText(http.StatusOK)
+		r.Error = nil
+	})
+
+	if err := req.Send(); err != nil {
+		return "", err
 	}
 
-	return "", errors.New("unable to determine bucket's region")
+	return s3.NormalizeBucketLocation(region), nil
 }
 
 // IsValidS3URLScheme returns true if the scheme is http:// or https://
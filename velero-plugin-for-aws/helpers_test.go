@@ -17,9 +17,19 @@ limitations under the License.
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestS3URL(t *testing.T) {
@@ -28,3 +38,92 @@ func TestS3URL(t *testing.T) {
 	assert.False(t, IsValidS3URLScheme("httpd://foo"))
 	assert.False(t, IsValidS3URLScheme(""))
 }
+
+func TestFindBucketRegion(t *testing.T) {
+	tests := []struct {
+		name           string
+		responses      map[string]error
+		defaultErr     error
+		region         string
+		expectedRegion string
+		expectedError  string
+	}{
+		{
+			name:           "found on first probe",
+			region:         "eu-central-1",
+			expectedRegion: "eu-central-1",
+		},
+		{
+			name: "first hint unreachable",
+			responses: map[string]error{
+				"us-east-1": awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("dial tcp: i/o timeout")),
+			},
+			region:         "eu-central-1",
+			expectedRegion: "eu-central-1",
+		},
+		{
+			name: "bucket missing everywhere",
+			responses: map[string]error{
+				"us-east-1":     awserr.New(notFoundCode, "Not Found", nil),
+				"us-gov-west-1": awserr.New("Forbidden", "Forbidden", nil),
+			},
+			defaultErr:    awserr.New(notFoundCode, "Not Found", nil),
+			expectedError: "us-east-1: bucket not found; cn-north-1: bucket not found; us-gov-west-1: access denied; us-iso-east-1: bucket not found; us-isob-east-1: bucket not found; eu-isoe-west-1: bucket not found",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			region, err := findBucketRegion(func(regionHint string) (string, error) {
+				if err, ok := tc.responses[regionHint]; ok {
+					return "", err
+				}
+				return tc.region, tc.defaultErr
+			})
+
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRegion, region)
+		})
+	}
+}
+
+func TestRegionHints(t *testing.T) {
+	for _, partition := range endpoints.DefaultPartitions() {
+		hints := regionHints(partition)
+		assert.True(t, len(hints) <= maxRegionHintsPerPartition)
+		// partitions without any regions yet aren't probed
+		assert.Equal(t, len(partition.Regions()) > 0, len(hints) > 0)
+
+		if preferred, ok := preferredRegionHints[partition.ID()]; ok {
+			assert.Equal(t, preferred, hints)
+		}
+	}
+
+	// opt-in regions aren't probed
+	assert.Equal(t, []string{"us-east-1", "us-west-2", "eu-west-1"}, regionHints(endpoints.AwsPartition()))
+}
+
+func TestHeadBucketRegion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request is signed for the wrong region, so S3 redirects
+		// without a Location header
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+		w.Header().Set(bucketRegionHeader, "eu-west-1")
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithMaxRetries(0))
+	require.NoError(t, err)
+
+	region, err := headBucketRegion(sess, "bucket", "us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", region)
+}
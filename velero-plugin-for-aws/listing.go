@@ -0,0 +1,282 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const listConcurrencyKey = "listConcurrency"
+
+// parseListConcurrency returns how many shards of a prefix ListObjects may
+// list at once. The default of 1 lists sequentially.
+func parseListConcurrency(config map[string]string) (int, error) {
+	val := config[listConcurrencyKey]
+	if val == "" {
+		return 1, nil
+	}
+
+	concurrency, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse %s (expected int)", listConcurrencyKey)
+	}
+	if concurrency < 1 {
+		return 0, errors.Errorf("invalid %s: must be at least 1", listConcurrencyKey)
+	}
+
+	return concurrency, nil
+}
+
+// errStopWalk can be returned by a walk function to stop walking without
+// failing.
+var errStopWalk = errors.New("stop walk")
+
+// objectInfo describes an object found by walkObjects.
+type objectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// walkObjects calls fn for every object under prefix, one page of at most
+// 1000 objects at a time, so that memory use doesn't grow with the number
+// of objects. Objects are walked in the order the store lists them, which
+// for S3 is ascending by key.
+//
+// If reverse is true, objects are walked in descending order of key, the
+// order ListObjects returns, which providers that list pseudo-folders as
+// objects (such as Quobyte) need in order to delete them after their
+// contents; see https://github.com/vmware-tanzu/velero/pull/999. Reversing
+// requires holding every object under prefix in memory, and is the only
+// case in which the prefix is listed in shards if listConcurrency is set.
+//
+// If fn returns errStopWalk, walking stops and walkObjects returns nil; any
+// other error stops walking and is returned.
+func (o *ObjectStore) walkObjects(ctx aws.Context, bucket, prefix string, reverse bool, fn func(objectInfo) error) error {
+	if !reverse {
+		return o.walkObjectPages(ctx, bucket, prefix, fn)
+	}
+
+	var (
+		objects []objectInfo
+		err     error
+	)
+	if o.listConcurrency > 1 {
+		objects, err = o.listObjectsSharded(ctx, bucket, prefix)
+	} else {
+		err = o.walkObjectPages(ctx, bucket, prefix, func(obj objectInfo) error {
+			objects = append(objects, obj)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key > objects[j].Key })
+
+	for _, obj := range objects {
+		if err := fn(obj); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *ObjectStore) walkObjectPages(ctx aws.Context, bucket, prefix string, fn func(objectInfo) error) error {
+	req := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}
+
+	var fnErr error
+	err := o.s3.ListObjectsV2PagesWithContext(ctx, req, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			fnErr = fn(objectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+			if fnErr != nil {
+				return false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if fnErr == errStopWalk {
+		return nil
+	}
+	return fnErr
+}
+
+// walkCommonPrefixes calls fn for every common prefix under prefix, one page
+// at a time, in the order the store lists them. If fn returns errStopWalk,
+// walking stops and walkCommonPrefixes returns nil; any other error stops
+// walking and is returned.
+func (o *ObjectStore) walkCommonPrefixes(ctx aws.Context, bucket, prefix, delimiter string, fn func(string) error) error {
+	req := &s3.ListObjectsV2Input{
+		Bucket:    &bucket,
+		Prefix:    &prefix,
+		Delimiter: &delimiter,
+	}
+
+	var fnErr error
+	err := o.s3.ListObjectsV2PagesWithContext(ctx, req, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, prefix := range page.CommonPrefixes {
+			if fnErr = fn(aws.StringValue(prefix.Prefix)); fnErr != nil {
+				return false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if fnErr == errStopWalk {
+		return nil
+	}
+	return fnErr
+}
+
+// listObjectsSharded lists the objects under prefix by first listing the
+// sub-prefixes one level below it and then listing each of them, up to
+// o.listConcurrency at a time. Objects are returned grouped by shard in listing
+// order, so the result doesn't depend on which shard finishes first. If
+// listing any shard fails the remaining shards are cancelled, and the error
+// of the first failed shard in listing order is returned. If ctx is cancelled,
+// its error is returned rather than the objects listed so far.
+func (o *ObjectStore) listObjectsSharded(ctx aws.Context, bucket, prefix string) ([]objectInfo, error) {
+	var (
+		objects []objectInfo
+		shards  []string
+	)
+
+	delimiter := "/"
+	req := &s3.ListObjectsV2Input{
+		Bucket:    &bucket,
+		Prefix:    &prefix,
+		Delimiter: &delimiter,
+	}
+	err := o.s3.ListObjectsV2PagesWithContext(ctx, req, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, objectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		for _, prefix := range page.CommonPrefixes {
+			shards = append(shards, aws.StringValue(prefix.Prefix))
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		results = make([][]objectInfo, len(shards))
+		errs    = make([]error, len(shards))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+
+	workers := o.listConcurrency
+	if workers > len(shards) {
+		workers = len(shards)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range jobs {
+				errs[shard] = o.walkObjectPages(ctx, bucket, shards[shard], func(obj objectInfo) error {
+					results[shard] = append(results[shard], obj)
+					return nil
+				})
+				if errs[shard] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for shard := range shards {
+		jobs <- shard
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// shards are only cancelled after another shard failed, so that
+	// shard's error is returned, but no shard's objects are missing if
+	// none is found
+	var canceled error
+	for shard, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !isCanceled(err) {
+			return nil, errors.Wrapf(err, "error listing %s", shards[shard])
+		}
+		if canceled == nil {
+			canceled = errors.Wrapf(err, "error listing %s", shards[shard])
+		}
+	}
+	if canceled != nil {
+		return nil, canceled
+	}
+
+	for _, shardObjects := range results {
+		objects = append(objects, shardObjects...)
+	}
+
+	o.log.WithField("prefix", prefix).Debugf("Listed %d objects in %d shards", len(objects), len(shards))
+
+	return objects, nil
+}
+
+// isCanceled returns true if err is from a request cancelled by its context.
+func isCanceled(err error) bool {
+	return errors.Cause(err) == context.Canceled || errorCode(err) == request.CanceledErrorCode
+}
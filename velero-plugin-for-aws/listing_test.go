@@ -0,0 +1,295 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// listPages feeds pages to the ListObjectsV2 callback until it asks to stop,
+// recording how many pages were read.
+func listPages(pages []*s3.ListObjectsV2Output, read *int) func(mock.Arguments) {
+	return func(args mock.Arguments) {
+		fn := args.Get(1).(func(*s3.ListObjectsV2Output, bool) bool)
+		for i, page := range pages {
+			*read = i + 1
+			if !fn(page, i == len(pages)-1) {
+				return
+			}
+		}
+	}
+}
+
+func TestWalkObjects(t *testing.T) {
+	modified := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	pages := []*s3.ListObjectsV2Output{
+		{Contents: []*s3.Object{
+			{Key: aws.String("restic/a"), Size: aws.Int64(1), LastModified: aws.Time(modified)},
+			{Key: aws.String("restic/b"), Size: aws.Int64(2), LastModified: aws.Time(modified)},
+		}},
+		{Contents: []*s3.Object{
+			{Key: aws.String("restic/c"), Size: aws.Int64(3), LastModified: aws.Time(modified)},
+		}},
+	}
+
+	tests := []struct {
+		name          string
+		reverse       bool
+		stopAt        string
+		fnErr         error
+		expectedKeys  []string
+		expectedPages int
+		expectedErr   string
+	}{
+		{
+			name:          "all pages in listed order",
+			expectedKeys:  []string{"restic/a", "restic/b", "restic/c"},
+			expectedPages: 2,
+		},
+		{
+			name:          "reverse",
+			reverse:       true,
+			expectedKeys:  []string{"restic/c", "restic/b", "restic/a"},
+			expectedPages: 2,
+		},
+		{
+			name:          "stop early without reading later pages",
+			stopAt:        "restic/b",
+			fnErr:         errStopWalk,
+			expectedKeys:  []string{"restic/a", "restic/b"},
+			expectedPages: 1,
+		},
+		{
+			name:          "walk function error",
+			stopAt:        "restic/a",
+			fnErr:         errors.New("oops"),
+			expectedKeys:  []string{"restic/a"},
+			expectedPages: 1,
+			expectedErr:   "oops",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mockS3)
+			defer s.AssertExpectations(t)
+
+			o := &ObjectStore{log: newLogger(), s3: s}
+
+			var read int
+			s.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+				Bucket: aws.String("bucket"),
+				Prefix: aws.String("restic/"),
+			}, mock.Anything).Run(listPages(pages, &read)).Return(nil)
+
+			var keys []string
+			err := o.walkObjects(aws.BackgroundContext(), "bucket", "restic/", tc.reverse, func(obj objectInfo) error {
+				keys = append(keys, obj.Key)
+				assert.Equal(t, modified, obj.LastModified)
+				if obj.Key == tc.stopAt {
+					return tc.fnErr
+				}
+				return nil
+			})
+
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.expectedErr, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedKeys, keys)
+			assert.Equal(t, tc.expectedPages, read)
+		})
+	}
+}
+
+func TestWalkObjectsSize(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+
+	var read int
+	s.On("ListObjectsV2PagesWithContext", mock.Anything, mock.Anything).Run(listPages([]*s3.ListObjectsV2Output{
+		{Contents: []*s3.Object{{Key: aws.String("a"), Size: aws.Int64(42)}}},
+	}, &read)).Return(nil)
+
+	var total int64
+	require.NoError(t, o.walkObjects(aws.BackgroundContext(), "bucket", "", false, func(obj objectInfo) error {
+		total += obj.Size
+		return nil
+	}))
+	assert.Equal(t, int64(42), total)
+}
+
+func TestWalkCommonPrefixes(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+
+	var read int
+	s.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+		Bucket:    aws.String("bucket"),
+		Prefix:    aws.String("backups/"),
+		Delimiter: aws.String("/"),
+	}, mock.Anything).Run(listPages([]*s3.ListObjectsV2Output{
+		{CommonPrefixes: []*s3.CommonPrefix{{Prefix: aws.String("backups/b1/")}}},
+		{CommonPrefixes: []*s3.CommonPrefix{{Prefix: aws.String("backups/b2/")}}},
+	}, &read)).Return(nil)
+
+	var prefixes []string
+	require.NoError(t, o.walkCommonPrefixes(aws.BackgroundContext(), "bucket", "backups/", "/", func(prefix string) error {
+		prefixes = append(prefixes, prefix)
+		return nil
+	}))
+	assert.Equal(t, []string{"backups/b1/", "backups/b2/"}, prefixes)
+}
+
+func TestParseListConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		val         string
+		expected    int
+		expectedErr string
+	}{
+		{name: "default", expected: 1},
+		{name: "valid", val: "8", expected: 8},
+		{name: "not an int", val: "many", expectedErr: "could not parse listConcurrency (expected int)"},
+		{name: "zero", val: "0", expectedErr: "invalid listConcurrency: must be at least 1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			concurrency, err := parseListConcurrency(map[string]string{listConcurrencyKey: tc.val})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, concurrency)
+		})
+	}
+}
+
+// onList mocks the listing of a single prefix, with or without a delimiter.
+func onList(s *mockS3, prefix string, delimited bool, page *s3.ListObjectsV2Output, err error) {
+	s.On("ListObjectsV2PagesWithContext", mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+		return *input.Prefix == prefix && (input.Delimiter != nil) == delimited
+	}), mock.Anything).Run(func(args mock.Arguments) {
+		if page != nil {
+			args.Get(1).(func(*s3.ListObjectsV2Output, bool) bool)(page, true)
+		}
+	}).Return(err)
+}
+
+func objects(keys ...string) *s3.ListObjectsV2Output {
+	page := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	return page
+}
+
+func TestListObjectsSharded(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, listConcurrency: 2}
+
+	onList(s, "restic/", true, &s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("restic/config")}},
+		CommonPrefixes: []*s3.CommonPrefix{
+			{Prefix: aws.String("restic/data/")},
+			{Prefix: aws.String("restic/index/")},
+			{Prefix: aws.String("restic/keys/")},
+		},
+	}, nil)
+	onList(s, "restic/data/", false, objects("restic/data/00", "restic/data/01"), nil)
+	onList(s, "restic/index/", false, objects("restic/index/a"), nil)
+	onList(s, "restic/keys/", false, objects("restic/keys/k"), nil)
+
+	keys, err := o.ListObjects("bucket", "restic/")
+	require.NoError(t, err)
+
+	// the same result, in the same order, as listing sequentially
+	assert.Equal(t, []string{
+		"restic/keys/k",
+		"restic/index/a",
+		"restic/data/01",
+		"restic/data/00",
+		"restic/config",
+	}, keys)
+}
+
+func TestListObjectsShardedError(t *testing.T) {
+	s := new(mockS3)
+
+	o := &ObjectStore{log: newLogger(), s3: s, listConcurrency: 4}
+
+	onList(s, "restic/", true, &s3.ListObjectsV2Output{
+		CommonPrefixes: []*s3.CommonPrefix{
+			{Prefix: aws.String("restic/data/")},
+			{Prefix: aws.String("restic/index/")},
+		},
+	}, nil)
+	onList(s, "restic/data/", false, objects("restic/data/00"), nil)
+	onList(s, "restic/index/", false, nil, errors.New("oops"))
+
+	_, err := o.ListObjects("bucket", "restic/")
+	require.Error(t, err)
+	assert.Equal(t, "error listing restic/index/: oops", err.Error())
+}
+
+func TestListObjectsShardedCanceled(t *testing.T) {
+	s := new(mockS3)
+
+	o := &ObjectStore{log: newLogger(), s3: s, listConcurrency: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	onList(s, "restic/", true, &s3.ListObjectsV2Output{
+		Contents: []*s3.Object{{Key: aws.String("restic/config")}},
+		CommonPrefixes: []*s3.CommonPrefix{
+			{Prefix: aws.String("restic/data/")},
+			{Prefix: aws.String("restic/index/")},
+		},
+	}, nil)
+	// the caller cancels while the shards are listed, so every shard
+	// fails with Canceled rather than a shard's own error
+	s.On("ListObjectsV2PagesWithContext", mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+		return input.Delimiter == nil
+	}), mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled))
+
+	listed, err := o.listObjectsSharded(ctx, "bucket", "restic/")
+	require.Error(t, err)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Nil(t, listed)
+}
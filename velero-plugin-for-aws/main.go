@@ -17,12 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 )
 
 func main() {
+	if cmd := lookupCommand(os.Args[1:]); cmd != nil {
+		if err := cmd.execute(os.Args[2:], os.Stdout, logrus.New()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	veleroplugin.NewServer().
 		BindFlags(pflag.CommandLine).
 		RegisterObjectStore("velero.io/aws", newAwsObjectStore).
@@ -31,9 +42,9 @@ func main() {
 }
 
 func newAwsObjectStore(logger logrus.FieldLogger) (interface{}, error) {
-	return newObjectStore(logger), nil
+	return newInstrumentedObjectStore(newObjectStore(logger)), nil
 }
 
 func newAwsVolumeSnapshotter(logger logrus.FieldLogger) (interface{}, error) {
-	return newVolumeSnapshotter(logger), nil
+	return newInstrumentedVolumeSnapshotter(newVolumeSnapshotter(logger)), nil
 }
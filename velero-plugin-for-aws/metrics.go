@@ -0,0 +1,371 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+const metricsAddressKey = "metricsAddress"
+
+const metricsNamespace = "velero_plugin_aws"
+
+// unknownErrorCode is the code label used for errors that didn't come from AWS.
+const unknownErrorCode = "Unknown"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	operationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Total number of object store and volume snapshotter operations.",
+		},
+		[]string{"operation", "bucket", "region"},
+	)
+
+	operationErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_errors_total",
+			Help:      "Total number of failed object store and volume snapshotter operations, by AWS error code.",
+		},
+		[]string{"operation", "bucket", "region", "code"},
+	)
+
+	operationDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of object store and volume snapshotter operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		},
+		[]string{"operation", "bucket", "region"},
+	)
+
+	uploadedBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Total number of bytes uploaded to the object store.",
+		},
+		[]string{"bucket", "region"},
+	)
+
+	downloadedBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Total number of bytes downloaded from the object store.",
+		},
+		[]string{"bucket", "region"},
+	)
+
+	awsRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "aws_requests_total",
+			Help:      "Total number of AWS API requests, by service, operation and result code.",
+		},
+		[]string{"service", "operation", "code"},
+	)
+
+	awsRequestRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "aws_request_retries_total",
+			Help:      "Total number of retried AWS API request attempts, by service and operation.",
+		},
+		[]string{"service", "operation"},
+	)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		operationsTotal,
+		operationErrorsTotal,
+		operationDurationSeconds,
+		uploadedBytesTotal,
+		downloadedBytesTotal,
+		awsRequestsTotal,
+		awsRequestRetriesTotal,
+	)
+}
+
+// metricsServers tracks the addresses the metrics endpoint is being served
+// on. Every plugin instance in the process shares the same metrics, so each
+// address is only served once no matter how many locations configure it.
+var (
+	metricsServersLock sync.Mutex
+	metricsServers     = map[string]bool{}
+)
+
+// startMetricsServer serves the plugin's metrics at /metrics on addr, unless
+// it's already being served there. Another process, e.g. a second plugin
+// binary in the same pod, may already be listening on addr; metrics are an
+// aid and not worth failing the location for, so that's only logged.
+func startMetricsServer(addr string, log logrus.FieldLogger) {
+	metricsServersLock.Lock()
+	defer metricsServersLock.Unlock()
+
+	if metricsServers[addr] {
+		return
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.WithError(err).Warnf("Could not start metrics server on %s, metrics won't be served by this process", addr)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.WithError(err).Errorf("Metrics server on %s stopped", addr)
+		}
+	}()
+
+	metricsServers[addr] = true
+	log.Infof("Serving metrics at %s/metrics", addr)
+}
+
+// awsRequestMetricsHandler counts every AWS API request made through a
+// session, including the part uploads made by s3manager, once all of its
+// retries are done.
+var awsRequestMetricsHandler = request.NamedHandler{
+	Name: "velero.AWSRequestMetricsHandler",
+	Fn: func(r *request.Request) {
+		code := "OK"
+		if r.Error != nil {
+			code = errorCode(r.Error)
+		}
+
+		awsRequestsTotal.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name, code).Inc()
+		if r.RetryCount > 0 {
+			awsRequestRetriesTotal.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).Add(float64(r.RetryCount))
+		}
+	},
+}
+
+// awsUploadMetricsHandler counts the bytes of every object and part uploaded
+// through a session. Counting the request instead of wrapping the body that's
+// uploaded keeps it seekable, so s3manager can upload its parts without
+// buffering them.
+var awsUploadMetricsHandler = request.NamedHandler{
+	Name: "velero.AWSUploadMetricsHandler",
+	Fn: func(r *request.Request) {
+		if r.Error != nil || r.HTTPRequest == nil || r.HTTPRequest.ContentLength <= 0 {
+			return
+		}
+
+		var bucket *string
+		switch params := r.Params.(type) {
+		case *s3.PutObjectInput:
+			bucket = params.Bucket
+		case *s3.UploadPartInput:
+			bucket = params.Bucket
+		default:
+			return
+		}
+
+		uploadedBytesTotal.WithLabelValues(aws.StringValue(bucket), aws.StringValue(r.Config.Region)).Add(float64(r.HTTPRequest.ContentLength))
+	},
+}
+
+// observeOperation records the outcome and duration of a plugin operation.
+func observeOperation(operation, bucket, region string, start time.Time, err error) {
+	operationsTotal.WithLabelValues(operation, bucket, region).Inc()
+	operationDurationSeconds.WithLabelValues(operation, bucket, region).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		operationErrorsTotal.WithLabelValues(operation, bucket, region, errorCode(err)).Inc()
+	}
+}
+
+// errorCode returns the AWS error code of err, looking through any errors
+// it wraps.
+func errorCode(err error) string {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return unknownErrorCode
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+var (
+	_ velero.ObjectStore       = &instrumentedObjectStore{}
+	_ velero.VolumeSnapshotter = &instrumentedVolumeSnapshotter{}
+)
+
+// instrumentedObjectStore records metrics for every ObjectStore operation.
+type instrumentedObjectStore struct {
+	*ObjectStore
+}
+
+func newInstrumentedObjectStore(o *ObjectStore) *instrumentedObjectStore {
+	return &instrumentedObjectStore{ObjectStore: o}
+}
+
+func (i *instrumentedObjectStore) Init(config map[string]string) error {
+	start := time.Now()
+	err := i.ObjectStore.Init(config)
+	observeOperation("Init", config[bucketKey], i.region, start, err)
+	if err != nil {
+		return err
+	}
+
+	if addr := config[metricsAddressKey]; addr != "" {
+		startMetricsServer(addr, i.log)
+	}
+
+	return nil
+}
+
+func (i *instrumentedObjectStore) PutObject(bucket, key string, body io.Reader) error {
+	start := time.Now()
+	err := i.ObjectStore.PutObject(bucket, key, body)
+	observeOperation("PutObject", bucket, i.region, start, err)
+	return err
+}
+
+func (i *instrumentedObjectStore) ObjectExists(bucket, key string) (bool, error) {
+	start := time.Now()
+	exists, err := i.ObjectStore.ObjectExists(bucket, key)
+	observeOperation("ObjectExists", bucket, i.region, start, err)
+	return exists, err
+}
+
+func (i *instrumentedObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := i.ObjectStore.GetObject(bucket, key)
+	observeOperation("GetObject", bucket, i.region, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: body, counter: downloadedBytesTotal.WithLabelValues(bucket, i.region)}, nil
+}
+
+func (i *instrumentedObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
+	start := time.Now()
+	prefixes, err := i.ObjectStore.ListCommonPrefixes(bucket, prefix, delimiter)
+	observeOperation("ListCommonPrefixes", bucket, i.region, start, err)
+	return prefixes, err
+}
+
+func (i *instrumentedObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
+	start := time.Now()
+	objects, err := i.ObjectStore.ListObjects(bucket, prefix)
+	observeOperation("ListObjects", bucket, i.region, start, err)
+	return objects, err
+}
+
+func (i *instrumentedObjectStore) DeleteObject(bucket, key string) error {
+	start := time.Now()
+	err := i.ObjectStore.DeleteObject(bucket, key)
+	observeOperation("DeleteObject", bucket, i.region, start, err)
+	return err
+}
+
+func (i *instrumentedObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
+	start := time.Now()
+	url, err := i.ObjectStore.CreateSignedURL(bucket, key, ttl)
+	observeOperation("CreateSignedURL", bucket, i.region, start, err)
+	return url, err
+}
+
+// instrumentedVolumeSnapshotter records metrics for every VolumeSnapshotter
+// operation. GetVolumeID and SetVolumeID only transform the PV and aren't
+// instrumented.
+type instrumentedVolumeSnapshotter struct {
+	*VolumeSnapshotter
+}
+
+func newInstrumentedVolumeSnapshotter(b *VolumeSnapshotter) *instrumentedVolumeSnapshotter {
+	return &instrumentedVolumeSnapshotter{VolumeSnapshotter: b}
+}
+
+func (i *instrumentedVolumeSnapshotter) Init(config map[string]string) error {
+	start := time.Now()
+	err := i.VolumeSnapshotter.Init(config)
+	observeOperation("Init", "", config[regionKey], start, err)
+	if err != nil {
+		return err
+	}
+
+	if addr := config[metricsAddressKey]; addr != "" {
+		startMetricsServer(addr, i.log)
+	}
+
+	return nil
+}
+
+func (i *instrumentedVolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, volumeAZ string, iops *int64) (string, error) {
+	start := time.Now()
+	volumeID, err := i.VolumeSnapshotter.CreateVolumeFromSnapshot(snapshotID, volumeType, volumeAZ, iops)
+	observeOperation("CreateVolumeFromSnapshot", "", i.region, start, err)
+	return volumeID, err
+}
+
+func (i *instrumentedVolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (string, *int64, error) {
+	start := time.Now()
+	volumeType, iops, err := i.VolumeSnapshotter.GetVolumeInfo(volumeID, volumeAZ)
+	observeOperation("GetVolumeInfo", "", i.region, start, err)
+	return volumeType, iops, err
+}
+
+func (i *instrumentedVolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[string]string) (string, error) {
+	start := time.Now()
+	snapshotID, err := i.VolumeSnapshotter.CreateSnapshot(volumeID, volumeAZ, tags)
+	observeOperation("CreateSnapshot", "", i.region, start, err)
+	return snapshotID, err
+}
+
+func (i *instrumentedVolumeSnapshotter) DeleteSnapshot(snapshotID string) error {
+	start := time.Now()
+	err := i.VolumeSnapshotter.DeleteSnapshot(snapshotID)
+	observeOperation("DeleteSnapshot", "", i.region, start, err)
+	return err
+}
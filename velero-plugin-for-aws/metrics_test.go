@@ -0,0 +1,131 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "SlowDown", errorCode(errors.Wrap(awserr.New("SlowDown", "slow down", nil), "error putting object")))
+	assert.Equal(t, unknownErrorCode, errorCode(errors.New("bad")))
+}
+
+func TestInstrumentedObjectStore(t *testing.T) {
+	s := new(mockS3)
+	u := new(mockS3Uploader)
+	defer s.AssertExpectations(t)
+	defer u.AssertExpectations(t)
+
+	o := newInstrumentedObjectStore(&ObjectStore{
+		log:        newLogger(),
+		region:     "metrics-region",
+		s3:         s,
+		s3Uploader: u,
+	})
+
+	u.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		// s3manager only uploads parts without buffering them if it can seek
+		_, seekable := args.Get(0).(*s3manager.UploadInput).Body.(io.Seeker)
+		assert.True(t, seekable)
+	}).Return(&s3manager.UploadOutput{}, nil)
+	s.On("GetObjectWithContext", mock.Anything).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("downloaded"))}, nil)
+	s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, awserr.New("AccessDenied", "Access Denied", nil))
+
+	require.NoError(t, o.PutObject("metrics-bucket", "key", strings.NewReader("uploaded content")))
+
+	body, err := o.GetObject("metrics-bucket", "key")
+	require.NoError(t, err)
+	_, _ = ioutil.ReadAll(body)
+
+	assert.Error(t, o.DeleteObject("metrics-bucket", "key"))
+
+	assert.Equal(t, float64(10), testutil.ToFloat64(downloadedBytesTotal.WithLabelValues("metrics-bucket", "metrics-region")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationsTotal.WithLabelValues("PutObject", "metrics-bucket", "metrics-region")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationsTotal.WithLabelValues("DeleteObject", "metrics-bucket", "metrics-region")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationErrorsTotal.WithLabelValues("DeleteObject", "metrics-bucket", "metrics-region", "AccessDenied")))
+}
+
+func TestAWSRequestMetricsHandler(t *testing.T) {
+	req := &request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: "metrics-ec2"},
+		Operation:  &request.Operation{Name: "CreateSnapshot"},
+		RetryCount: 2,
+		Error:      awserr.New("RequestLimitExceeded", "slow down", nil),
+	}
+
+	awsRequestMetricsHandler.Fn(req)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(awsRequestsTotal.WithLabelValues("metrics-ec2", "CreateSnapshot", "RequestLimitExceeded")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(awsRequestRetriesTotal.WithLabelValues("metrics-ec2", "CreateSnapshot")))
+}
+
+func TestAWSUploadMetricsHandler(t *testing.T) {
+	newRequest := func(params interface{}, contentLength int64) *request.Request {
+		return &request.Request{
+			Config:      aws.Config{Region: aws.String("metrics-upload-region")},
+			Params:      params,
+			HTTPRequest: &http.Request{ContentLength: contentLength},
+		}
+	}
+
+	awsUploadMetricsHandler.Fn(newRequest(&s3.PutObjectInput{Bucket: aws.String("metrics-upload-bucket")}, 16))
+	awsUploadMetricsHandler.Fn(newRequest(&s3.UploadPartInput{Bucket: aws.String("metrics-upload-bucket")}, 100))
+	awsUploadMetricsHandler.Fn(newRequest(&s3.GetObjectInput{Bucket: aws.String("metrics-upload-bucket")}, 50))
+
+	failed := newRequest(&s3.UploadPartInput{Bucket: aws.String("metrics-upload-bucket")}, 100)
+	failed.Error = awserr.New("SlowDown", "slow down", nil)
+	awsUploadMetricsHandler.Fn(failed)
+
+	assert.Equal(t, float64(116), testutil.ToFloat64(uploadedBytesTotal.WithLabelValues("metrics-upload-bucket", "metrics-upload-region")))
+}
+
+func TestInitWithMetricsAddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := newInstrumentedObjectStore(newObjectStore(newLogger()))
+	assert.NoError(t, o.Init(map[string]string{
+		bucketKey:          "bucket",
+		regionKey:          "us-east-1",
+		credentialsFileKey: writeCredentialsFile(t, dir),
+		metricsAddressKey:  listener.Addr().String(),
+	}))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mirrorBucketKey           = "mirrorBucket"
+	mirrorRegionKey           = "mirrorRegion"
+	mirrorS3URLKey            = "mirrorS3Url"
+	mirrorS3ForcePathStyleKey = "mirrorS3ForcePathStyle"
+	mirrorProfileKey          = "mirrorProfile"
+)
+
+// mirror is a second bucket, possibly in another region or with another
+// provider, that every object written to the location is also written to,
+// so that the location can still be read when its bucket can't.
+type mirror struct {
+	bucket string
+	// store reads and writes the mirror bucket with the same options as
+	// the location, e.g. its prefix and soft delete.
+	store *ObjectStore
+}
+
+// newMirror creates the location's mirror from config, or returns nil if
+// it has none. The mirror uses the location's credentials and connection
+// settings unless config overrides them for the mirror.
+func (o *ObjectStore) newMirror(config map[string]string, transport transportOptions, retry retryOptions) (*mirror, error) {
+	var (
+		bucket         = config[mirrorBucketKey]
+		region         = config[mirrorRegionKey]
+		s3URL          = config[mirrorS3URLKey]
+		profile        = config[mirrorProfileKey]
+		forcePathStyle bool
+		err            error
+	)
+
+	if bucket == "" {
+		for _, key := range []string{mirrorRegionKey, mirrorS3URLKey, mirrorS3ForcePathStyleKey, mirrorProfileKey} {
+			if config[key] != "" {
+				return nil, errors.Errorf("%s requires %s", key, mirrorBucketKey)
+			}
+		}
+		return nil, nil
+	}
+
+	if val := config[mirrorS3ForcePathStyleKey]; val != "" {
+		if forcePathStyle, err = strconv.ParseBool(val); err != nil {
+			return nil, errors.Wrapf(err, "could not parse %s (expected bool)", mirrorS3ForcePathStyleKey)
+		}
+	}
+	if profile == "" {
+		profile = config[credentialProfileKey]
+	}
+
+	if s3URL == "" && region == "" {
+		regionConfig := aws.NewConfig().WithHTTPClient(newHTTPClient(transport))
+		request.WithRetryer(regionConfig, newRetryer(retry, o.log))
+
+		regionSessionOptions, err := newSessionOptions(*regionConfig, profile, config[caCertKey], config[credentialsFileKey])
+		if err != nil {
+			return nil, err
+		}
+
+		if region, err = GetBucketRegion(bucket, regionSessionOptions); err != nil {
+			return nil, err
+		}
+	} else if region == "" {
+		// S3-compatible providers mostly ignore the region
+		region = o.region
+	}
+
+	mirrorConfig, err := newAWSConfig(s3URL, region, forcePathStyle)
+	if err != nil {
+		return nil, err
+	}
+	mirrorConfig.HTTPClient = newHTTPClient(transport)
+	request.WithRetryer(mirrorConfig, newRetryer(retry, o.log))
+
+	sessionOptions, err := newSessionOptions(*mirrorConfig, profile, config[caCertKey], config[credentialsFileKey])
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := getSession(sessionOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// KMS keys belong to a region, so the mirror is only encrypted with
+	// the location's serverSideEncryption
+	store := &ObjectStore{
+		log:                  o.log.WithField("mirrorBucket", bucket),
+		region:               region,
+		prefix:               o.prefix,
+		trash:                o.trash,
+		deleteAllVersions:    o.deleteAllVersions,
+		labels:               o.labels,
+		bucketOwner:          o.bucketOwner,
+		listConcurrency:      o.listConcurrency,
+		signatureVersion:     o.signatureVersion,
+		serverSideEncryption: o.serverSideEncryption,
+	}
+
+	// the mirror's operations are audited on behalf of its own credentials
+	if store.audit, err = newAuditLogger(config[auditLogPathKey], sess, s3URL == "", region, store.log); err != nil {
+		return nil, err
+	}
+
+	client := store.newS3Client(sess)
+	store.s3 = client
+	store.preSignS3 = client
+	store.s3Uploader = s3manager.NewUploaderWithClient(client)
+
+	// the mirror bucket may enforce object ownership when the location's
+	// bucket doesn't, or the other way around
+	if !o.readOnly {
+		store.acl = initACL(config[aclKey], client, bucket, store.log)
+	}
+
+	return &mirror{bucket: bucket, store: store}, nil
+}
+
+// putMirrored writes body to the object at key in both the location's bucket
+// and its mirror at the same time, reading body only once. It fails if
+// either write fails.
+func (o *ObjectStore) putMirrored(ctx aws.Context, bucket, key string, body io.Reader) error {
+	reader, writer := io.Pipe()
+
+	mirrorErr := make(chan error, 1)
+	go func() {
+		err := o.mirror.store.upload(ctx, o.mirror.bucket, key, reader)
+		// keep reading if the mirror's upload failed, so that the
+		// primary's upload isn't blocked
+		_, _ = io.Copy(ioutil.Discard, reader)
+		mirrorErr <- err
+	}()
+
+	err := o.upload(ctx, bucket, key, io.TeeReader(body, writer))
+	if err != nil {
+		// abort the mirror's upload too
+		writer.CloseWithError(err)
+	} else {
+		writer.Close()
+	}
+
+	o.logMirrorResult("PutObject", "primary", bucket, key, err)
+	errMirror := <-mirrorErr
+	o.mirror.store.audit.record(auditEntry{Operation: "ObjectStore.PutObject", Bucket: o.mirror.bucket, Key: key}, errMirror)
+	o.logMirrorResult("PutObject", "mirror", o.mirror.bucket, key, errMirror)
+
+	if err != nil {
+		return errors.Wrapf(err, "error putting object %s", key)
+	}
+	return errors.Wrapf(errMirror, "error putting object %s in mirror bucket %s", key, o.mirror.bucket)
+}
+
+// withMirrorFailover calls fn with the location's store and bucket, and
+// again with the mirror's if that fails and the location has a mirror.
+func (o *ObjectStore) withMirrorFailover(operation, bucket, key string, fn func(store *ObjectStore, bucket string) error) error {
+	err := fn(o, bucket)
+	if err == nil || o.mirror == nil {
+		return err
+	}
+
+	o.logMirrorResult(operation, "primary", bucket, key, err)
+	errMirror := fn(o.mirror.store, o.mirror.bucket)
+	o.logMirrorResult(operation, "mirror", o.mirror.bucket, key, errMirror)
+	if errMirror != nil {
+		// the primary's error is the one worth reporting
+		return err
+	}
+
+	return nil
+}
+
+func (o *ObjectStore) logMirrorResult(operation, target, bucket, key string, err error) {
+	log := o.log.WithFields(logrus.Fields{
+		"operation": operation,
+		"target":    target,
+		"bucket":    bucket,
+		"key":       key,
+	})
+	if err != nil {
+		log.WithError(err).Warn("Mirrored operation failed")
+		return
+	}
+	log.Debug("Mirrored operation succeeded")
+}
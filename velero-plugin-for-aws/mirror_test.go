@@ -0,0 +1,299 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newMirroredObjectStore returns an ObjectStore for "bucket" that is
+// mirrored to "mirror-bucket".
+func newMirroredObjectStore() (o *ObjectStore, primary, secondary *mockS3, primaryUploader, secondaryUploader *mockS3Uploader) {
+	primary, secondary = new(mockS3), new(mockS3)
+	primaryUploader, secondaryUploader = new(mockS3Uploader), new(mockS3Uploader)
+
+	o = &ObjectStore{log: newLogger(), s3: primary, s3Uploader: primaryUploader}
+	o.mirror = &mirror{
+		bucket: "mirror-bucket",
+		store:  &ObjectStore{log: newLogger(), s3: secondary, s3Uploader: secondaryUploader},
+	}
+
+	return o, primary, secondary, primaryUploader, secondaryUploader
+}
+
+// readBody reads the uploaded body into buf.
+func readBody(buf *bytes.Buffer) func(mock.Arguments) {
+	return func(args mock.Arguments) {
+		_, _ = buf.ReadFrom(args.Get(0).(*s3manager.UploadInput).Body)
+	}
+}
+
+func TestPutObjectMirrored(t *testing.T) {
+	content := strings.Repeat("backup contents ", 1024)
+
+	t.Run("both succeed", func(t *testing.T) {
+		o, _, _, primaryUploader, secondaryUploader := newMirroredObjectStore()
+
+		var primaryBody, secondaryBody bytes.Buffer
+		primaryUploader.On("UploadWithContext", mock.MatchedBy(func(input *s3manager.UploadInput) bool {
+			return *input.Bucket == "bucket"
+		})).Run(readBody(&primaryBody)).Return(&s3manager.UploadOutput{}, nil)
+		secondaryUploader.On("UploadWithContext", mock.MatchedBy(func(input *s3manager.UploadInput) bool {
+			return *input.Bucket == "mirror-bucket"
+		})).Run(readBody(&secondaryBody)).Return(&s3manager.UploadOutput{}, nil)
+
+		require.NoError(t, o.PutObject("bucket", "backups/b1/b1.tar.gz", strings.NewReader(content)))
+		assert.Equal(t, content, primaryBody.String())
+		assert.Equal(t, content, secondaryBody.String())
+	})
+
+	t.Run("mirror fails", func(t *testing.T) {
+		o, _, _, primaryUploader, secondaryUploader := newMirroredObjectStore()
+
+		// the mirror fails without reading the body, which mustn't block
+		// the primary's upload
+		var primaryBody bytes.Buffer
+		primaryUploader.On("UploadWithContext", mock.Anything).Run(readBody(&primaryBody)).Return(&s3manager.UploadOutput{}, nil)
+		secondaryUploader.On("UploadWithContext", mock.Anything).Return(&s3manager.UploadOutput{}, errors.New("AccessDenied"))
+
+		err := o.PutObject("bucket", "backups/b1/b1.tar.gz", strings.NewReader(content))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error putting object backups/b1/b1.tar.gz in mirror bucket mirror-bucket: AccessDenied")
+		assert.Equal(t, content, primaryBody.String())
+	})
+
+	t.Run("primary fails", func(t *testing.T) {
+		o, _, _, primaryUploader, secondaryUploader := newMirroredObjectStore()
+
+		var secondaryBody bytes.Buffer
+		primaryUploader.On("UploadWithContext", mock.Anything).Return(&s3manager.UploadOutput{}, errors.New("SlowDown"))
+		secondaryUploader.On("UploadWithContext", mock.Anything).Run(readBody(&secondaryBody)).Return(&s3manager.UploadOutput{}, nil)
+
+		err := o.PutObject("bucket", "backups/b1/b1.tar.gz", strings.NewReader(content))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error putting object backups/b1/b1.tar.gz: SlowDown")
+	})
+}
+
+func TestGetObjectMirrorFailover(t *testing.T) {
+	o, primary, secondary, _, _ := newMirroredObjectStore()
+
+	primary.On("GetObjectWithContext", mock.Anything).Return(&s3.GetObjectOutput{}, errors.New("InternalError"))
+	secondary.On("GetObjectWithContext", &s3.GetObjectInput{
+		Bucket: aws.String("mirror-bucket"),
+		Key:    aws.String("backups/b1/velero-backup.json"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil)
+
+	body, err := o.GetObject("bucket", "backups/b1/velero-backup.json")
+	require.NoError(t, err)
+
+	content, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+
+	// if both fail, the primary's error is returned
+	o, primary, secondary, _, _ = newMirroredObjectStore()
+	primary.On("GetObjectWithContext", mock.Anything).Return(&s3.GetObjectOutput{}, errors.New("InternalError"))
+	secondary.On("GetObjectWithContext", mock.Anything).Return(&s3.GetObjectOutput{}, errors.New("AccessDenied"))
+
+	_, err = o.GetObject("bucket", "backups/b1/velero-backup.json")
+	assert.EqualError(t, err, "error getting object backups/b1/velero-backup.json: InternalError")
+}
+
+func TestObjectExistsMirrorFailover(t *testing.T) {
+	o, primary, secondary, _, _ := newMirroredObjectStore()
+
+	primary.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{}, errors.New("InternalError"))
+	secondary.On("HeadObjectWithContext", &s3.HeadObjectInput{
+		Bucket: aws.String("mirror-bucket"),
+		Key:    aws.String("backups/b1/velero-backup.json"),
+	}).Return(&s3.HeadObjectOutput{}, nil)
+
+	exists, err := o.ObjectExists("bucket", "backups/b1/velero-backup.json")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// an object that doesn't exist isn't an error, so the mirror isn't asked
+	o, primary, secondary, _, _ = newMirroredObjectStore()
+	primary.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{}, awserr.New(notFoundCode, "not found", nil))
+
+	exists, err = o.ObjectExists("bucket", "backups/b1/velero-backup.json")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	secondary.AssertNotCalled(t, "HeadObjectWithContext", mock.Anything)
+}
+
+func TestListObjectsMirrorFailover(t *testing.T) {
+	o, primary, secondary, _, _ := newMirroredObjectStore()
+
+	primary.On("ListObjectsV2PagesWithContext", mock.Anything, mock.Anything).Return(errors.New("InternalError"))
+
+	var read int
+	secondary.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+		Bucket: aws.String("mirror-bucket"),
+		Prefix: aws.String("backups/b1/"),
+	}, mock.Anything).Run(listPages([]*s3.ListObjectsV2Output{
+		{Contents: []*s3.Object{{Key: aws.String("backups/b1/b1.tar.gz")}, {Key: aws.String("backups/b1/velero-backup.json")}}},
+	}, &read)).Return(nil)
+
+	keys, err := o.ListObjects("bucket", "backups/b1/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backups/b1/velero-backup.json", "backups/b1/b1.tar.gz"}, keys)
+}
+
+func TestDeleteObjectMirrored(t *testing.T) {
+	o, primary, secondary, _, _ := newMirroredObjectStore()
+
+	primary.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("backups/b1/b1.tar.gz"),
+	}).Return(&s3.DeleteObjectOutput{}, nil)
+	secondary.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("mirror-bucket"),
+		Key:    aws.String("backups/b1/b1.tar.gz"),
+	}).Return(&s3.DeleteObjectOutput{}, errors.New("AccessDenied"))
+
+	err := o.DeleteObject("bucket", "backups/b1/b1.tar.gz")
+	assert.EqualError(t, err, "error deleting object backups/b1/b1.tar.gz from mirror bucket mirror-bucket: error deleting object backups/b1/b1.tar.gz: AccessDenied")
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+
+	// the mirror is audited, and checks the bucket owner, like the location
+	dir, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var primaryReq, mirrorReq *http.Request
+	primaryServer := httptest.NewServer(respond("", &primaryReq))
+	defer primaryServer.Close()
+	mirrorServer := httptest.NewServer(respond("", &mirrorReq))
+	defer mirrorServer.Close()
+
+	auditLogPath := filepath.Join(dir, "audit.log")
+	o = newObjectStore(newLogger())
+	require.NoError(t, o.Init(map[string]string{
+		bucketKey:                 "bucket",
+		regionKey:                 "us-east-1",
+		s3URLKey:                  primaryServer.URL,
+		s3ForcePathStyleKey:       "true",
+		credentialsFileKey:        writeCredentialsFile(t, dir),
+		auditLogPathKey:           auditLogPath,
+		aclKey:                    s3.ObjectCannedACLBucketOwnerFullControl,
+		expectedBucketOwnerKey:    "123456789012",
+		mirrorBucketKey:           "mirror-bucket",
+		mirrorS3URLKey:            mirrorServer.URL,
+		mirrorS3ForcePathStyleKey: "true",
+	}))
+	assert.Equal(t, s3.ObjectCannedACLBucketOwnerFullControl, o.mirror.store.acl)
+
+	require.NoError(t, o.DeleteObject("bucket", "backups/b1/b1.tar.gz"))
+	require.NotNil(t, mirrorReq)
+	assert.Equal(t, http.MethodDelete, mirrorReq.Method)
+	assert.Equal(t, "123456789012", mirrorReq.Header.Get("X-Amz-Expected-Bucket-Owner"))
+
+	content, err := ioutil.ReadFile(auditLogPath)
+	require.NoError(t, err)
+	entries := readAuditEntries(t, bytes.NewBuffer(content))
+	require.Len(t, entries, 2)
+
+	var buckets []string
+	for _, entry := range entries {
+		assert.Equal(t, "ObjectStore.DeleteObject", entry.Operation)
+		assert.Equal(t, "backups/b1/b1.tar.gz", entry.Key)
+		assert.Equal(t, auditOutcomeSuccess, entry.Outcome)
+		buckets = append(buckets, entry.Bucket)
+	}
+	assert.ElementsMatch(t, []string{"bucket", "mirror-bucket"}, buckets)
+}
+
+func TestNewMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	credentialsFile := writeCredentialsFile(t, dir)
+
+	tests := []struct {
+		name        string
+		config      map[string]string
+		expectedErr string
+	}{
+		{
+			name:        "mirror settings without a bucket",
+			config:      map[string]string{mirrorS3URLKey: "http://minio:9000"},
+			expectedErr: "mirrorS3Url requires mirrorBucket",
+		},
+		{
+			name:        "invalid path style",
+			config:      map[string]string{mirrorBucketKey: "mirror-bucket", mirrorS3ForcePathStyleKey: "maybe"},
+			expectedErr: "could not parse mirrorS3ForcePathStyle (expected bool)",
+		},
+		{
+			name:        "invalid URL",
+			config:      map[string]string{mirrorBucketKey: "mirror-bucket", mirrorS3URLKey: "minio:9000"},
+			expectedErr: "Invalid s3 url minio:9000",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &ObjectStore{log: newLogger()}
+			_, err := o.newMirror(tc.config, transportOptions{}, retryOptions{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+
+	o := newObjectStore(newLogger())
+	require.NoError(t, o.Init(map[string]string{
+		bucketKey:                 "bucket",
+		regionKey:                 "us-east-1",
+		prefixKey:                 "velero",
+		kmsKeyIDKey:               "my-key",
+		serverSideEncryptionKey:   "AES256",
+		credentialsFileKey:        credentialsFile,
+		mirrorBucketKey:           "mirror-bucket",
+		mirrorS3URLKey:            "http://minio:9000",
+		mirrorS3ForcePathStyleKey: "true",
+	}))
+
+	require.NotNil(t, o.mirror)
+	assert.Equal(t, "mirror-bucket", o.mirror.bucket)
+	assert.Equal(t, "velero", o.mirror.store.prefix)
+	// S3-compatible mirrors default to the location's region
+	assert.Equal(t, "us-east-1", o.mirror.store.region)
+	// KMS keys belong to a region, so they aren't used for the mirror
+	assert.Empty(t, o.mirror.store.kmsKeyID)
+	assert.Equal(t, "AES256", o.mirror.store.serverSideEncryption)
+	assert.Nil(t, o.mirror.store.mirror)
+}
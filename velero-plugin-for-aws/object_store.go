@@ -17,11 +17,8 @@ limitations under the License.
 package main
 
 import (
-	"crypto/tls"
 	"io"
-	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -55,30 +52,70 @@ const (
 )
 
 type s3Interface interface {
-	HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
-	GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error)
-	ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error
-	DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	HeadBucketWithContext(ctx aws.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, error)
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	GetObjectTaggingWithContext(ctx aws.Context, input *s3.GetObjectTaggingInput, opts ...request.Option) (*s3.GetObjectTaggingOutput, error)
+	ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+	ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error
+	DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error)
+	CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error)
+	CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartCopyWithContext(ctx aws.Context, input *s3.UploadPartCopyInput, opts ...request.Option) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error)
 	GetObjectRequest(input *s3.GetObjectInput) (req *request.Request, output *s3.GetObjectOutput)
+	PutObjectRequest(input *s3.PutObjectInput) (req *request.Request, output *s3.PutObjectOutput)
+	HeadObjectRequest(input *s3.HeadObjectInput) (req *request.Request, output *s3.HeadObjectOutput)
+	UploadPartRequest(input *s3.UploadPartInput) (req *request.Request, output *s3.UploadPartOutput)
+}
+
+type s3UploaderInterface interface {
+	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 }
 
 type ObjectStore struct {
 	log                  logrus.FieldLogger
+	region               string
+	prefix               string
+	trash                trashOptions
+	deleteAllVersions    bool
+	readOnly             bool
+	labels               objectLabelOptions
+	bucketOwner          bucketOwnerOptions
+	acl                  string
+	listConcurrency      int
+	signedURLs           signedURLOptions
+	audit                *auditLogger
 	s3                   s3Interface
 	preSignS3            s3Interface
-	s3Uploader           *s3manager.Uploader
+	publicEndpoints      map[string]s3Interface
+	mirror               *mirror
+	s3Uploader           s3UploaderInterface
 	kmsKeyID             string
 	signatureVersion     string
 	serverSideEncryption string
+	// command is set if the object store is initialized for a plugin
+	// binary command, so Init leaves out what changes the bucket: it
+	// doesn't provision it, write access check objects to it or purge its
+	// trash.
+	command bool
 }
 
 func newObjectStore(logger logrus.FieldLogger) *ObjectStore {
 	return &ObjectStore{log: logger}
 }
 
+// newCommandObjectStore returns an object store for a plugin binary command,
+// which only reads or writes what the command asks for.
+func newCommandObjectStore(logger logrus.FieldLogger) *ObjectStore {
+	return &ObjectStore{log: logger, command: true}
+}
+
 func isValidSignatureVersion(signatureVersion string) bool {
 	switch signatureVersion {
-	case "1", "4":
+	case "1", "2", "4":
 		return true
 	}
 	return false
@@ -96,31 +133,86 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		credentialProfileKey,
 		serverSideEncryptionKey,
 		insecureSkipTLSVerifyKey,
+		connectTimeoutKey,
+		responseHeaderTimeoutKey,
+		socketTimeoutKey,
+		maxIdleConnsKey,
+		enableHTTP2Key,
+		maxRetriesKey,
+		minRetryDelayKey,
+		maxRetryDelayKey,
+		retryJitterKey,
+		validateOnInitKey,
+		metricsAddressKey,
+		tracingEndpointKey,
+		tracingInsecureKey,
+		auditLogPathKey,
+		softDeleteKey,
+		trashRetentionKey,
+		deleteAllVersionsKey,
+		listConcurrencyKey,
+		signedURLMaxTTLKey,
+		signedURLContentDispositionKey,
+		cloudFrontURLKey,
+		cloudFrontKeyPairIDKey,
+		cloudFrontPrivateKeyFileKey,
+		cloudFrontAllowedIPRangeKey,
+		publicEndpointsKey,
+		defaultPublicEndpointKey,
+		mirrorBucketKey,
+		mirrorRegionKey,
+		mirrorS3URLKey,
+		mirrorS3ForcePathStyleKey,
+		mirrorProfileKey,
+		useAccelerateKey,
+		useDualStackKey,
+		useFIPSKey,
+		readOnlyKey,
+		objectTagsKey,
+		objectTagTemplatesKey,
+		objectMetadataKey,
+		requesterPaysKey,
+		expectedBucketOwnerKey,
+		aclKey,
+		createBucketIfMissingKey,
+		provisionExistingBucketKey,
+		bucketEncryptionKey,
+		blockPublicAccessKey,
+		abortIncompleteUploadsDaysKey,
+		objectLockModeKey,
+		objectLockRetentionDaysKey,
 	); err != nil {
 		return err
 	}
 
+	if err := initTracing(config, o.log); err != nil {
+		return err
+	}
+
 	var (
-		region                   = config[regionKey]
-		s3URL                    = config[s3URLKey]
-		publicURL                = config[publicURLKey]
-		kmsKeyID                 = config[kmsKeyIDKey]
-		s3ForcePathStyleVal      = config[s3ForcePathStyleKey]
-		signatureVersion         = config[signatureVersionKey]
-		credentialProfile        = config[credentialProfileKey]
-		credentialsFile          = config[credentialsFileKey]
-		serverSideEncryption     = config[serverSideEncryptionKey]
-		insecureSkipTLSVerifyVal = config[insecureSkipTLSVerifyKey]
+		region               = config[regionKey]
+		s3URL                = config[s3URLKey]
+		publicURL            = config[publicURLKey]
+		kmsKeyID             = config[kmsKeyIDKey]
+		s3ForcePathStyleVal  = config[s3ForcePathStyleKey]
+		signatureVersion     = config[signatureVersionKey]
+		credentialProfile    = config[credentialProfileKey]
+		credentialsFile      = config[credentialsFileKey]
+		serverSideEncryption = config[serverSideEncryptionKey]
+		validateOnInitVal    = config[validateOnInitKey]
+		deleteAllVersionsVal = config[deleteAllVersionsKey]
 
 		// note that bucket is automatically added to the config map
 		// by the server from the ObjectStorageProviderConfig so
 		// doesn't need to be explicitly set by the user within
 		// config.
-		bucket                = config[bucketKey]
-		caCert                = config[caCertKey]
-		s3ForcePathStyle      bool
-		insecureSkipTLSVerify bool
-		err                   error
+		bucket            = config[bucketKey]
+		prefix            = config[prefixKey]
+		caCert            = config[caCertKey]
+		s3ForcePathStyle  bool
+		validateOnInit    bool
+		deleteAllVersions bool
+		err               error
 	)
 
 	if s3ForcePathStyleVal != "" {
@@ -129,47 +221,115 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		}
 	}
 
-	// AWS (not an alternate S3-compatible API) and region not
-	// explicitly specified: determine the bucket's region
-	if s3URL == "" && region == "" {
-		var err error
+	if validateOnInitVal != "" {
+		if validateOnInit, err = strconv.ParseBool(validateOnInitVal); err != nil {
+			return errors.Wrapf(err, "could not parse %s (expected bool)", validateOnInitKey)
+		}
+	}
 
-		region, err = GetBucketRegion(bucket)
-		if err != nil {
-			return err
+	if deleteAllVersionsVal != "" {
+		if deleteAllVersions, err = strconv.ParseBool(deleteAllVersionsVal); err != nil {
+			return errors.Wrapf(err, "could not parse %s (expected bool)", deleteAllVersionsKey)
 		}
 	}
 
-	serverConfig, err := newAWSConfig(s3URL, region, s3ForcePathStyle)
+	transport, err := parseTransportOptions(config)
+	if err != nil {
+		return err
+	}
+
+	retry, err := parseRetryOptions(config)
+	if err != nil {
+		return err
+	}
+
+	trash, err := parseTrashOptions(config)
 	if err != nil {
 		return err
 	}
 
-	if insecureSkipTLSVerifyVal != "" {
-		if insecureSkipTLSVerify, err = strconv.ParseBool(insecureSkipTLSVerifyVal); err != nil {
-			return errors.Wrapf(err, "could not parse %s (expected bool)", insecureSkipTLSVerifyKey)
+	listConcurrency, err := parseListConcurrency(config)
+	if err != nil {
+		return err
+	}
+
+	readOnly, err := parseReadOnly(config)
+	if err != nil {
+		return err
+	}
+
+	labels, err := parseObjectLabelOptions(config, trash)
+	if err != nil {
+		return err
+	}
+
+	bucketOwner, err := parseBucketOwnerOptions(config)
+	if err != nil {
+		return err
+	}
+
+	acl, err := parseACL(config)
+	if err != nil {
+		return err
+	}
+
+	provisioning, err := parseBucketProvisioningOptions(config, trash, deleteAllVersions)
+	if err != nil {
+		return err
+	}
+
+	signedURLs, err := parseSignedURLOptions(config)
+	if err != nil {
+		return err
+	}
+
+	publicEndpoints, defaultPublicEndpoint, err := parsePublicEndpoints(config)
+	if err != nil {
+		return err
+	}
+
+	endpointOpts, err := parseEndpointOptions(config)
+	if err != nil {
+		return err
+	}
+
+	accessPoint, err := parseAccessPointARN(bucket)
+	if err != nil {
+		return err
+	}
+	if accessPoint != nil {
+		if err := validateAccessPointConfig(accessPoint, config, endpointOpts); err != nil {
+			return err
 		}
 	}
 
-	if insecureSkipTLSVerify {
-		defaultTransport := http.DefaultTransport.(*http.Transport)
-		serverConfig.HTTPClient = &http.Client{
-			// Copied from net/http
-			Transport: &http.Transport{
-				Proxy:                 defaultTransport.Proxy,
-				DialContext:           defaultTransport.DialContext,
-				MaxIdleConns:          defaultTransport.MaxIdleConns,
-				IdleConnTimeout:       defaultTransport.IdleConnTimeout,
-				TLSHandshakeTimeout:   defaultTransport.TLSHandshakeTimeout,
-				ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
-				// Set insecureSkipVerify true
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
+	// AWS (not an alternate S3-compatible API) and region not
+	// explicitly specified: determine the bucket's region using
+	// the same credentials and connection settings as every
+	// other request to this location
+	if s3URL == "" && region == "" {
+		regionConfig := aws.NewConfig().WithHTTPClient(newHTTPClient(transport))
+		request.WithRetryer(regionConfig, newRetryer(retry, o.log))
+
+		regionSessionOptions, err := newSessionOptions(*regionConfig, credentialProfile, caCert, credentialsFile)
+		if err != nil {
+			return err
+		}
+
+		region, err = GetBucketRegion(bucket, regionSessionOptions)
+		if err != nil {
+			return err
 		}
 	}
 
+	serverConfig, err := newAWSConfig(s3URL, region, s3ForcePathStyle)
+	if err != nil {
+		return err
+	}
+	endpointOpts.apply(serverConfig)
+	serverConfig.HTTPClient = newHTTPClient(transport)
+	request.WithRetryer(serverConfig, newRetryer(retry, o.log))
+
 	sessionOptions, err := newSessionOptions(*serverConfig, credentialProfile, caCert, credentialsFile)
 	if err != nil {
 		return err
@@ -180,8 +340,19 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		return err
 	}
 
-	o.s3 = s3.New(serverSession)
-	o.s3Uploader = s3manager.NewUploader(serverSession)
+	if o.audit, err = newAuditLogger(config[auditLogPathKey], serverSession, s3URL == "", region, o.log); err != nil {
+		return err
+	}
+
+	o.region = region
+	o.prefix = prefix
+	o.trash = trash
+	o.deleteAllVersions = deleteAllVersions
+	o.readOnly = readOnly
+	o.labels = labels
+	o.bucketOwner = bucketOwner
+	o.listConcurrency = listConcurrency
+	o.signedURLs = signedURLs
 	o.kmsKeyID = kmsKeyID
 	o.serverSideEncryption = serverSideEncryption
 
@@ -192,29 +363,111 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		o.signatureVersion = signatureVersion
 	}
 
-	if publicURL != "" {
-		publicConfig, err := newAWSConfig(publicURL, region, s3ForcePathStyle)
-		if err != nil {
+	s3Client := o.newS3Client(serverSession)
+	o.s3 = s3Client
+	o.s3Uploader = s3manager.NewUploaderWithClient(s3Client)
+	if provisioning.enabled && !o.command {
+		if err := provisionBucket(s3Client, bucket, region, prefix, provisioning, o.log); err != nil {
 			return err
 		}
+	}
+
+	if !readOnly {
+		o.acl = initACL(acl, s3Client, bucket, o.log)
+	}
+
+	// newPublicClient creates a client that signs URLs for a public
+	// endpoint, with the same credentials and connection settings as the
+	// server's client
+	newPublicClient := func(url string, forcePathStyle bool, caCert string) (*s3.S3, error) {
+		publicConfig, err := newAWSConfig(url, region, forcePathStyle)
+		if err != nil {
+			return nil, err
+		}
+		publicConfig.HTTPClient = newHTTPClient(transport)
+		request.WithRetryer(publicConfig, newRetryer(retry, o.log))
 
 		publicSessionOptions, err := newSessionOptions(*publicConfig, credentialProfile, caCert, credentialsFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		publicSession, err := getSession(publicSessionOptions)
 		if err != nil {
+			return nil, err
+		}
+		return o.newS3Client(publicSession), nil
+	}
+
+	switch {
+	case publicURL != "":
+		if o.preSignS3, err = newPublicClient(publicURL, s3ForcePathStyle, caCert); err != nil {
 			return err
 		}
-		o.preSignS3 = s3.New(publicSession)
-	} else {
+	case len(publicEndpoints) > 0:
+		o.publicEndpoints = make(map[string]s3Interface, len(publicEndpoints))
+		for _, endpoint := range publicEndpoints {
+			forcePathStyle := s3ForcePathStyle
+			if endpoint.S3ForcePathStyle != nil {
+				forcePathStyle = *endpoint.S3ForcePathStyle
+			}
+			endpointCACert := caCert
+			if endpoint.CACert != "" {
+				endpointCACert = endpoint.CACert
+			}
+
+			client, err := newPublicClient(endpoint.URL, forcePathStyle, endpointCACert)
+			if err != nil {
+				return errors.WithMessagef(err, "error creating client for public endpoint %q", endpoint.Name)
+			}
+			o.publicEndpoints[endpoint.Name] = client
+		}
+		o.preSignS3 = o.publicEndpoints[defaultPublicEndpoint]
+	default:
 		o.preSignS3 = o.s3
 	}
 
+	if readOnly {
+		o.log.WithField("bucket", bucket).Info("Backup storage location is read-only, writes and deletes will be rejected")
+	}
+
+	if validateOnInit && !o.command {
+		if err := o.checkAccess(bucket, prefix); err != nil {
+			return err
+		}
+	}
+
+	if o.mirror, err = o.newMirror(config, transport, retry); err != nil {
+		return err
+	}
+
+	// purging the trash deletes objects, so it's left to a writable
+	// location, and to Velero's object stores rather than commands
+	if trash.retention > 0 && !readOnly && !o.command {
+		o.startTrashPurge(bucket)
+		if o.mirror != nil {
+			o.mirror.store.startTrashPurge(o.mirror.bucket)
+		}
+	}
+
 	return nil
 }
 
+// newS3Client creates an S3 client that signs its requests with the
+// location's signature version. Signature version 1 is only used for
+// presigned URLs, so its clients sign with version 4.
+func (o *ObjectStore) newS3Client(sess *session.Session) *s3.S3 {
+	client := s3.New(sess)
+	if o.signatureVersion == "2" {
+		client.Handlers.Sign.Swap(v4.SignRequestHandler.Name, v2SignRequestHandler)
+	}
+	if o.bucketOwner.enabled() {
+		client.Handlers.Build.PushBackNamed(o.bucketOwner.buildHandler())
+		client.Handlers.UnmarshalError.PushBackNamed(o.bucketOwner.errorHandler())
+	}
+	return client
+}
+
 // newSessionOptions creates a session.Options with the given config and profile. If
 // caCert and credentialsFile are provided, these will be used for the CustomCABundle
 // and the credentials for the session.
@@ -264,33 +517,76 @@ func newAWSConfig(url, region string, forcePathStyle bool) (*aws.Config, error)
 	return awsConfig, nil
 }
 
-func (o *ObjectStore) PutObject(bucket, key string, body io.Reader) error {
+func (o *ObjectStore) PutObject(bucket, key string, body io.Reader) (err error) {
+	ctx, span := startSpan("ObjectStore.PutObject", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.PutObject", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("PutObject", bucket, key); err != nil {
+		return err
+	}
+
+	if o.mirror != nil {
+		return o.putMirrored(ctx, bucket, key, body)
+	}
+
+	return errors.Wrapf(o.upload(ctx, bucket, key, body), "error putting object %s", key)
+}
+
+func (o *ObjectStore) upload(ctx aws.Context, bucket, key string, body io.Reader) error {
+	tagging, err := o.objectTagging(key)
+	if err != nil {
+		return err
+	}
+
 	req := &s3manager.UploadInput{
-		Bucket: &bucket,
-		Key:    &key,
-		Body:   body,
+		Bucket:   &bucket,
+		Key:      &key,
+		Body:     body,
+		Tagging:  tagging,
+		Metadata: o.objectMetadata(),
+		ACL:      o.objectACL(),
 	}
 
+	req.ServerSideEncryption, req.SSEKMSKeyId = o.encryption()
+
+	_, err = o.s3Uploader.UploadWithContext(ctx, req)
+	return o.explainACLError(err)
+}
+
+// encryption returns the server-side encryption (SSE) algorithm and KMS key
+// ID, if any, that new objects are written with.
+func (o *ObjectStore) encryption() (serverSideEncryption, kmsKeyID *string) {
 	switch {
 	// if kmsKeyID is not empty, assume a server-side encryption (SSE)
 	// algorithm of "aws:kms"
 	case o.kmsKeyID != "":
-		req.ServerSideEncryption = aws.String("aws:kms")
-		req.SSEKMSKeyId = &o.kmsKeyID
+		return aws.String("aws:kms"), aws.String(o.kmsKeyID)
 	// otherwise, use the SSE algorithm specified, if any
 	case o.serverSideEncryption != "":
-		req.ServerSideEncryption = aws.String(o.serverSideEncryption)
+		return aws.String(o.serverSideEncryption), nil
 	}
-
-	_, err := o.s3Uploader.Upload(req)
-
-	return errors.Wrapf(err, "error putting object %s", key)
+	return nil, nil
 }
 
 const notFoundCode = "NotFound"
 
 // ObjectExists checks if there is an object with the given key in the object storage bucket.
-func (o *ObjectStore) ObjectExists(bucket, key string) (bool, error) {
+func (o *ObjectStore) ObjectExists(bucket, key string) (exists bool, err error) {
+	ctx, span := startSpan("ObjectStore.ObjectExists", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() { endSpan(span, err) }()
+
+	err = o.withMirrorFailover("ObjectExists", bucket, key, func(store *ObjectStore, bucket string) error {
+		var err error
+		exists, err = store.objectExists(ctx, bucket, key)
+		return err
+	})
+	return exists, err
+}
+
+func (o *ObjectStore) objectExists(ctx aws.Context, bucket, key string) (bool, error) {
 	log := o.log.WithFields(
 		logrus.Fields{
 			"bucket": bucket,
@@ -304,7 +600,7 @@ func (o *ObjectStore) ObjectExists(bucket, key string) (bool, error) {
 	}
 
 	log.Debug("Checking if object exists")
-	if _, err := o.s3.HeadObject(req); err != nil {
+	if _, err := o.s3.HeadObjectWithContext(ctx, req); err != nil {
 		log.Debug("Checking for AWS specific error information")
 		if aerr, ok := err.(awserr.Error); ok {
 			log.WithFields(
@@ -329,88 +625,151 @@ func (o *ObjectStore) ObjectExists(bucket, key string) (bool, error) {
 	return true, nil
 }
 
-func (o *ObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
-	req := &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	}
+func (o *ObjectStore) GetObject(bucket, key string) (body io.ReadCloser, err error) {
+	ctx, span := startSpan("ObjectStore.GetObject", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() { endSpan(span, err) }()
 
-	res, err := o.s3.GetObject(req)
+	err = o.withMirrorFailover("GetObject", bucket, key, func(store *ObjectStore, bucket string) error {
+		req := &s3.GetObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+		}
+
+		res, err := store.s3.GetObjectWithContext(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "error getting object %s", key)
+		}
+
+		body = res.Body
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "error getting object %s", key)
+		return nil, err
 	}
 
-	return res.Body, nil
+	return body, nil
 }
 
-func (o *ObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
-	req := &s3.ListObjectsV2Input{
-		Bucket:    &bucket,
-		Prefix:    &prefix,
-		Delimiter: &delimiter,
-	}
+func (o *ObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) (prefixes []string, err error) {
+	ctx, span := startSpan("ObjectStore.ListCommonPrefixes", bucketAttribute.String(bucket), keyAttribute.String(prefix))
+	defer func() { endSpan(span, err) }()
 
 	var ret []string
-	err := o.s3.ListObjectsV2Pages(req, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-		for _, prefix := range page.CommonPrefixes {
-			ret = append(ret, *prefix.Prefix)
-		}
-		return !lastPage
+	err = o.walkCommonPrefixes(ctx, bucket, prefix, delimiter, func(prefix string) error {
+		ret = append(ret, prefix)
+		return nil
 	})
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	return ret, nil
 }
 
-func (o *ObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
-	req := &s3.ListObjectsV2Input{
-		Bucket: &bucket,
-		Prefix: &prefix,
-	}
+func (o *ObjectStore) ListObjects(bucket, prefix string) (objects []string, err error) {
+	ctx, span := startSpan("ObjectStore.ListObjects", bucketAttribute.String(bucket), keyAttribute.String(prefix))
+	defer func() { endSpan(span, err) }()
 
 	var ret []string
-	err := o.s3.ListObjectsV2Pages(req, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-		for _, obj := range page.Contents {
-			ret = append(ret, *obj.Key)
-		}
-		return !lastPage
+	err = o.withMirrorFailover("ListObjects", bucket, prefix, func(store *ObjectStore, bucket string) error {
+		ret = nil
+		// objects are returned in reverse order so that the deletion logic
+		// deletes the objects before the pseudo-folder prefix object
+		return store.walkObjects(ctx, bucket, prefix, true, func(obj objectInfo) error {
+			ret = append(ret, obj.Key)
+			return nil
+		})
 	})
-
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
-	// ensure that returned objects are in a consistent order so that the deletion logic deletes the objects before
-	// the pseudo-folder prefix object for s3 providers (such as Quobyte) that return the pseudo-folder as an object.
-	// See https://github.com/vmware-tanzu/velero/pull/999
-	sort.Sort(sort.Reverse(sort.StringSlice(ret)))
-
 	return ret, nil
 }
 
-func (o *ObjectStore) DeleteObject(bucket, key string) error {
+// DeleteObject deletes the object at key or, if soft delete is enabled, moves
+// it to the trash. Objects that are already in the trash are always deleted.
+// The object is deleted from the location's mirror too, if it has one.
+func (o *ObjectStore) DeleteObject(bucket, key string) (err error) {
+	ctx, span := startSpan("ObjectStore.DeleteObject", bucketAttribute.String(bucket), keyAttribute.String(key))
+
+	var trashKey string
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.DeleteObject", Bucket: bucket, Key: key, TrashKey: trashKey}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("DeleteObject", bucket, key); err != nil {
+		return err
+	}
+
+	if trashKey, err = o.deleteObject(ctx, bucket, key); err != nil {
+		return err
+	}
+
+	if o.mirror != nil {
+		var mirrorTrashKey string
+		mirrorTrashKey, err = o.mirror.store.deleteObject(ctx, o.mirror.bucket, key)
+		o.mirror.store.audit.record(auditEntry{Operation: "ObjectStore.DeleteObject", Bucket: o.mirror.bucket, Key: key, TrashKey: mirrorTrashKey}, err)
+		o.logMirrorResult("DeleteObject", "mirror", o.mirror.bucket, key, err)
+		return errors.WithMessagef(err, "error deleting object %s from mirror bucket %s", key, o.mirror.bucket)
+	}
+
+	return nil
+}
+
+// deleteObject deletes or trashes the object at key and returns the key it
+// was moved to if it was trashed.
+func (o *ObjectStore) deleteObject(ctx aws.Context, bucket, key string) (string, error) {
+	if o.trash.enabled && !strings.HasPrefix(key, o.trashPrefix()) {
+		return o.moveToTrash(ctx, bucket, key)
+	}
+
+	return "", o.removeObject(ctx, bucket, key)
+}
+
+// removeObject permanently deletes the object at key, including all of its
+// versions if the location is configured to.
+func (o *ObjectStore) removeObject(ctx aws.Context, bucket, key string) error {
+	if o.deleteAllVersions {
+		return o.removeAllVersions(ctx, bucket, key)
+	}
+
 	req := &s3.DeleteObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
 	}
 
-	_, err := o.s3.DeleteObject(req)
+	_, err := o.s3.DeleteObjectWithContext(ctx, req)
 
 	return errors.Wrapf(err, "error deleting object %s", key)
 }
 
-func (o *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
-	req, _ := o.preSignS3.GetObjectRequest(&s3.GetObjectInput{
+func (o *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (url string, err error) {
+	_, span := startSpan("ObjectStore.CreateSignedURL", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CreateSignedURL", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if limited := o.signedURLs.ttl(ttl); limited != ttl {
+		o.log.WithField("key", key).Debugf("Limiting signed URL TTL from %s to %s", ttl, limited)
+		ttl = limited
+	}
+
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	})
+	}
+	o.signedURLs.setResponseHeaders(input, key)
 
-	if o.signatureVersion == "1" {
-		req.Handlers.Sign.Remove(v4.SignRequestHandler)
-		req.Handlers.Sign.PushBackNamed(v1SignRequestHandler)
+	if o.signedURLs.cloudFront != nil {
+		return o.signedURLs.cloudFront.sign(key, input, time.Now().Add(ttl))
 	}
 
+	req, _ := o.preSignS3.GetObjectRequest(input)
+
+	o.useSignatureVersion(req)
+
 	return req.Presign(ttl)
 }
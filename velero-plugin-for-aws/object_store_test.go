@@ -23,6 +23,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -31,6 +32,7 @@ import (
 
 func TestIsValidSignatureVersion(t *testing.T) {
 	assert.True(t, isValidSignatureVersion("1"))
+	assert.True(t, isValidSignatureVersion("2"))
 	assert.True(t, isValidSignatureVersion("4"))
 	assert.False(t, isValidSignatureVersion("3"))
 }
@@ -39,31 +41,100 @@ type mockS3 struct {
 	mock.Mock
 }
 
-func (m *mockS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+func (m *mockS3) HeadBucketWithContext(ctx aws.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.HeadBucketOutput), args.Error(1)
+}
+
+func (m *mockS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
 }
 
-func (m *mockS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+func (m *mockS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
-func (m *mockS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+func (m *mockS3) GetObjectTaggingWithContext(ctx aws.Context, input *s3.GetObjectTaggingInput, opts ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetObjectTaggingOutput), args.Error(1)
+}
+
+func (m *mockS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
 	args := m.Called(input, fn)
 	return args.Error(0)
 }
 
-func (m *mockS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+func (m *mockS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
 }
 
+func (m *mockS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
+}
+
+func (m *mockS3) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.CreateMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3) UploadPartCopyWithContext(ctx aws.Context, input *s3.UploadPartCopyInput, opts ...request.Option) (*s3.UploadPartCopyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.UploadPartCopyOutput), args.Error(1)
+}
+
+func (m *mockS3) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.CompleteMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.AbortMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	args := m.Called(input, fn)
+	return args.Error(0)
+}
+
+func (m *mockS3) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.DeleteObjectsOutput), args.Error(1)
+}
+
 func (m *mockS3) GetObjectRequest(input *s3.GetObjectInput) (req *request.Request, output *s3.GetObjectOutput) {
 	args := m.Called(input)
 	return args.Get(0).(*request.Request), args.Get(1).(*s3.GetObjectOutput)
 }
 
+func (m *mockS3) PutObjectRequest(input *s3.PutObjectInput) (req *request.Request, output *s3.PutObjectOutput) {
+	args := m.Called(input)
+	return args.Get(0).(*request.Request), args.Get(1).(*s3.PutObjectOutput)
+}
+
+func (m *mockS3) HeadObjectRequest(input *s3.HeadObjectInput) (req *request.Request, output *s3.HeadObjectOutput) {
+	args := m.Called(input)
+	return args.Get(0).(*request.Request), args.Get(1).(*s3.HeadObjectOutput)
+}
+
+func (m *mockS3) UploadPartRequest(input *s3.UploadPartInput) (req *request.Request, output *s3.UploadPartOutput) {
+	args := m.Called(input)
+	return args.Get(0).(*request.Request), args.Get(1).(*s3.UploadPartOutput)
+}
+
+type mockS3Uploader struct {
+	mock.Mock
+}
+
+func (m *mockS3Uploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3manager.UploadOutput), args.Error(1)
+}
+
 func TestObjectExists(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -107,7 +178,7 @@ func TestObjectExists(t *testing.T) {
 				Key:    aws.String(key),
 			}
 
-			s.On("HeadObject", req).Return(&s3.HeadObjectOutput{}, tc.errorResponse)
+			s.On("HeadObjectWithContext", req).Return(&s3.HeadObjectOutput{}, tc.errorResponse)
 
 			exists, err := o.ObjectExists(bucket, key)
 
@@ -0,0 +1,286 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const (
+	objectTagsKey         = "objectTags"
+	objectTagTemplatesKey = "objectTagTemplates"
+	objectMetadataKey     = "objectMetadata"
+)
+
+// S3's limits on object tags.
+const (
+	maxObjectTags        = 10
+	maxObjectTagKeyLen   = 128
+	maxObjectTagValueLen = 256
+)
+
+// objectLabelOptions are the tags and user metadata set on every object the
+// location uploads, e.g. for tag-based lifecycle rules, cost allocation or
+// ABAC policies.
+type objectLabelOptions struct {
+	tags      map[string]string
+	templates map[string]*template.Template
+	metadata  map[string]string
+}
+
+// objectKeyInfo is what a tag template can refer to. It's parsed from the
+// object's key relative to the location's prefix, which follows Velero's
+// layout, e.g. backups/<backup>/<backup>-logs.gz.
+type objectKeyInfo struct {
+	// Key is the object's key relative to the location's prefix.
+	Key string
+	// Category is the top-level directory of Key, e.g. backups, restores
+	// or metadata, or empty if Key isn't in a directory.
+	Category string
+	// BackupName is the name of the backup the object belongs to, if it's
+	// under backups/.
+	BackupName string
+	// RestoreName is the name of the restore the object belongs to, if it's
+	// under restores/.
+	RestoreName string
+	// File is the object's file name, e.g. <backup>-logs.gz.
+	File string
+}
+
+func parseObjectLabelOptions(config map[string]string, trash trashOptions) (objectLabelOptions, error) {
+	var (
+		opts objectLabelOptions
+		err  error
+	)
+
+	if opts.tags, err = parseKeyValues(config, objectTagsKey); err != nil {
+		return objectLabelOptions{}, err
+	}
+
+	templates, err := parseKeyValues(config, objectTagTemplatesKey)
+	if err != nil {
+		return objectLabelOptions{}, err
+	}
+
+	for key, value := range opts.tags {
+		if err := validateObjectTag(key, value); err != nil {
+			return objectLabelOptions{}, errors.WithMessagef(err, "invalid %s", objectTagsKey)
+		}
+	}
+
+	if len(templates) > 0 {
+		opts.templates = make(map[string]*template.Template, len(templates))
+	}
+	for key, text := range templates {
+		if err := validateObjectTag(key, ""); err != nil {
+			return objectLabelOptions{}, errors.WithMessagef(err, "invalid %s", objectTagTemplatesKey)
+		}
+		if _, ok := opts.tags[key]; ok {
+			return objectLabelOptions{}, errors.Errorf("invalid %s: tag %s is also set in %s", objectTagTemplatesKey, key, objectTagsKey)
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return objectLabelOptions{}, errors.Wrapf(err, "could not parse %s template for tag %s", objectTagTemplatesKey, key)
+		}
+		// a template that refers to anything but objectKeyInfo would
+		// otherwise only fail when an object is uploaded
+		if err := tmpl.Execute(&bytes.Buffer{}, objectKeyInfo{}); err != nil {
+			return objectLabelOptions{}, errors.Wrapf(err, "invalid %s template for tag %s", objectTagTemplatesKey, key)
+		}
+		opts.templates[key] = tmpl
+	}
+
+	// a trashed object keeps its tags and is tagged with when it was trashed
+	limit := maxObjectTags
+	if trash.enabled {
+		limit--
+	}
+	if count := len(opts.tags) + len(opts.templates); count > limit {
+		return objectLabelOptions{}, errors.Errorf("invalid %s: %d tags are set but at most %d are allowed", objectTagsKey, count, limit)
+	}
+
+	if opts.metadata, err = parseKeyValues(config, objectMetadataKey); err != nil {
+		return objectLabelOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// parseKeyValues parses the comma-separated list of key=value pairs of
+// config[configKey].
+func parseKeyValues(config map[string]string, configKey string) (map[string]string, error) {
+	val := config[configKey]
+	if val == "" {
+		return nil, nil
+	}
+
+	values := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, errors.Errorf("could not parse %s (expected a comma-separated list of key=value pairs)", configKey)
+		}
+		if _, ok := values[key]; ok {
+			return nil, errors.Errorf("invalid %s: %s is set more than once", configKey, key)
+		}
+		values[key] = strings.TrimSpace(parts[1])
+	}
+
+	return values, nil
+}
+
+func validateObjectTag(key, value string) error {
+	if len(key) > maxObjectTagKeyLen {
+		return errors.Errorf("tag key %s is longer than %d characters", key, maxObjectTagKeyLen)
+	}
+	if len(value) > maxObjectTagValueLen {
+		return errors.Errorf("value of tag %s is longer than %d characters", key, maxObjectTagValueLen)
+	}
+	if strings.HasPrefix(strings.ToLower(key), "aws:") {
+		return errors.Errorf("tag key %s uses the reserved aws: prefix", key)
+	}
+	return nil
+}
+
+// parseObjectKey parses key, relative to prefix, as a key of Velero's layout.
+func parseObjectKey(prefix, key string) objectKeyInfo {
+	if prefix != "" {
+		key = strings.TrimPrefix(key, strings.TrimSuffix(prefix, "/")+"/")
+	}
+
+	info := objectKeyInfo{Key: key, File: path.Base(key)}
+
+	parts := strings.Split(key, "/")
+	if len(parts) > 1 {
+		info.Category = parts[0]
+	}
+	if len(parts) > 2 {
+		switch info.Category {
+		case "backups":
+			info.BackupName = parts[1]
+		case "restores":
+			info.RestoreName = parts[1]
+		}
+	}
+
+	return info
+}
+
+// objectTags returns the tags to set on the object at key. Templated tags
+// whose value is empty for key, e.g. a backup name tag on an object that
+// doesn't belong to a backup, aren't set.
+func (o *ObjectStore) objectTags(key string) (url.Values, error) {
+	tags := make(url.Values, len(o.labels.tags)+len(o.labels.templates))
+	for tag, value := range o.labels.tags {
+		tags.Set(tag, value)
+	}
+
+	if len(o.labels.templates) == 0 {
+		return tags, nil
+	}
+
+	info := parseObjectKey(o.prefix, key)
+	for tag, tmpl := range o.labels.templates {
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, info); err != nil {
+			return nil, errors.Wrapf(err, "error rendering tag %s for object %s", tag, key)
+		}
+		if value.Len() == 0 {
+			continue
+		}
+		if err := validateObjectTag(tag, value.String()); err != nil {
+			return nil, errors.WithMessagef(err, "invalid tag for object %s", key)
+		}
+		tags.Set(tag, value.String())
+	}
+
+	return tags, nil
+}
+
+// objectTagging returns the URL-encoded tags to set on the object at key,
+// or nil if it has none.
+func (o *ObjectStore) objectTagging(key string) (*string, error) {
+	tags, err := o.objectTags(key)
+	if err != nil || len(tags) == 0 {
+		return nil, err
+	}
+	return aws.String(tags.Encode()), nil
+}
+
+// objectMetadata returns the user metadata to set on every object, or nil
+// if there's none.
+func (o *ObjectStore) objectMetadata() map[string]*string {
+	if len(o.labels.metadata) == 0 {
+		return nil
+	}
+	return aws.StringMap(o.labels.metadata)
+}
+
+// objectLabels are the tags and user metadata of an object.
+type objectLabels struct {
+	Tags     map[string]string `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// getObjectLabels reads back the tags and user metadata of the object at
+// key, for the get-labels command. The metadata is read with HeadObject, and
+// the tags with GetObjectTagging, because HeadObject doesn't return them.
+// Metadata keys are lowercased, as S3 stores them, because the SDK
+// canonicalizes the header they're read from.
+func (o *ObjectStore) getObjectLabels(bucket, key string) (labels *objectLabels, err error) {
+	ctx, span := startSpan("ObjectStore.getObjectLabels", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() { endSpan(span, err) }()
+
+	head, err := o.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting metadata of object %s", key)
+	}
+
+	labels = &objectLabels{
+		Tags:     map[string]string{},
+		Metadata: map[string]string{},
+	}
+	for k, v := range head.Metadata {
+		labels.Metadata[strings.ToLower(k)] = aws.StringValue(v)
+	}
+
+	res, err := o.s3.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting tags of object %s", key)
+	}
+	for _, tag := range res.TagSet {
+		labels.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseObjectLabelOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		trash       trashOptions
+		expectedErr string
+	}{
+		{
+			name: "valid",
+			config: map[string]string{
+				objectTagsKey:         "team=platform, cost-center = 1234",
+				objectTagTemplatesKey: "velero.io/backup={{.BackupName}},velero.io/category={{.Category}}",
+				objectMetadataKey:     "owner=platform",
+			},
+		},
+		{
+			name:        "not key=value",
+			config:      map[string]string{objectTagsKey: "team"},
+			expectedErr: "could not parse objectTags (expected a comma-separated list of key=value pairs)",
+		},
+		{
+			name:        "duplicate key",
+			config:      map[string]string{objectMetadataKey: "owner=a,owner=b"},
+			expectedErr: "invalid objectMetadata: owner is set more than once",
+		},
+		{
+			name:        "reserved prefix",
+			config:      map[string]string{objectTagsKey: "aws:team=platform"},
+			expectedErr: "invalid objectTags: tag key aws:team uses the reserved aws: prefix",
+		},
+		{
+			name:        "value too long",
+			config:      map[string]string{objectTagsKey: "team=" + strings.Repeat("a", 257)},
+			expectedErr: "invalid objectTags: value of tag team is longer than 256 characters",
+		},
+		{
+			name: "tag set twice",
+			config: map[string]string{
+				objectTagsKey:         "team=platform",
+				objectTagTemplatesKey: "team={{.Category}}",
+			},
+			expectedErr: "invalid objectTagTemplates: tag team is also set in objectTags",
+		},
+		{
+			name:        "invalid template",
+			config:      map[string]string{objectTagTemplatesKey: "backup={{.BackupName"},
+			expectedErr: "could not parse objectTagTemplates template for tag backup",
+		},
+		{
+			name:        "unknown field",
+			config:      map[string]string{objectTagTemplatesKey: "backup={{.Backup}}"},
+			expectedErr: "invalid objectTagTemplates template for tag backup",
+		},
+		{
+			name:        "too many tags",
+			config:      map[string]string{objectTagsKey: "a=1,b=2,c=3,d=4,e=5,f=6,g=7,h=8,i=9,j=10,k=11"},
+			expectedErr: "invalid objectTags: 11 tags are set but at most 10 are allowed",
+		},
+		{
+			name:        "too many tags with soft delete",
+			config:      map[string]string{objectTagsKey: "a=1,b=2,c=3,d=4,e=5,f=6,g=7,h=8,i=9,j=10"},
+			trash:       trashOptions{enabled: true},
+			expectedErr: "invalid objectTags: 10 tags are set but at most 9 are allowed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseObjectLabelOptions(tc.config, tc.trash)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestParseObjectKey(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		key      string
+		expected objectKeyInfo
+	}{
+		{
+			prefix: "velero",
+			key:    "velero/backups/b1/b1-logs.gz",
+			expected: objectKeyInfo{
+				Key:        "backups/b1/b1-logs.gz",
+				Category:   "backups",
+				BackupName: "b1",
+				File:       "b1-logs.gz",
+			},
+		},
+		{
+			key: "restores/r1/restore-r1-results.gz",
+			expected: objectKeyInfo{
+				Key:         "restores/r1/restore-r1-results.gz",
+				Category:    "restores",
+				RestoreName: "r1",
+				File:        "restore-r1-results.gz",
+			},
+		},
+		{
+			key: "metadata/revision",
+			expected: objectKeyInfo{
+				Key:      "metadata/revision",
+				Category: "metadata",
+				File:     "revision",
+			},
+		},
+		{
+			prefix: "velero/",
+			key:    "velero/.velero-access-check-1",
+			expected: objectKeyInfo{
+				Key:  ".velero-access-check-1",
+				File: ".velero-access-check-1",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseObjectKey(tc.prefix, tc.key))
+		})
+	}
+}
+
+func TestPutObjectLabels(t *testing.T) {
+	labels, err := parseObjectLabelOptions(map[string]string{
+		objectTagsKey:         "team=platform",
+		objectTagTemplatesKey: "velero.io/backup={{.BackupName}},velero.io/category={{.Category}}",
+		objectMetadataKey:     "owner=platform",
+	}, trashOptions{})
+	require.NoError(t, err)
+
+	uploader := new(mockS3Uploader)
+	o := &ObjectStore{log: newLogger(), prefix: "velero", labels: labels, s3Uploader: uploader}
+
+	var input *s3manager.UploadInput
+	uploader.On("UploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input = args.Get(0).(*s3manager.UploadInput)
+	}).Return(&s3manager.UploadOutput{}, nil)
+
+	require.NoError(t, o.PutObject("bucket", "velero/backups/b1/b1.tar.gz", strings.NewReader("contents")))
+
+	tags, err := url.ParseQuery(aws.StringValue(input.Tagging))
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"team":               {"platform"},
+		"velero.io/backup":   {"b1"},
+		"velero.io/category": {"backups"},
+	}, tags)
+	assert.Equal(t, map[string]*string{"owner": aws.String("platform")}, input.Metadata)
+
+	// tags that are empty for the key aren't set
+	require.NoError(t, o.PutObject("bucket", "velero/metadata/revision", strings.NewReader("contents")))
+
+	tags, err = url.ParseQuery(aws.StringValue(input.Tagging))
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"team":               {"platform"},
+		"velero.io/category": {"metadata"},
+	}, tags)
+
+	// without any tags none are sent
+	o.labels = objectLabelOptions{}
+	require.NoError(t, o.PutObject("bucket", "velero/metadata/revision", strings.NewReader("contents")))
+	assert.Nil(t, input.Tagging)
+	assert.Nil(t, input.Metadata)
+}
+
+func TestGetObjectLabels(t *testing.T) {
+	s3Mock := new(mockS3)
+	o := &ObjectStore{log: newLogger(), s3: s3Mock}
+
+	s3Mock.On("HeadObjectWithContext", &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("backups/b1/b1.tar.gz"),
+	}).Return(&s3.HeadObjectOutput{Metadata: map[string]*string{"Owner": aws.String("platform")}}, nil)
+	s3Mock.On("GetObjectTaggingWithContext", &s3.GetObjectTaggingInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("backups/b1/b1.tar.gz"),
+	}).Return(&s3.GetObjectTaggingOutput{TagSet: []*s3.Tag{
+		{Key: aws.String("team"), Value: aws.String("platform")},
+		{Key: aws.String("velero.io/backup"), Value: aws.String("b1")},
+	}}, nil)
+
+	labels, err := o.getObjectLabels("bucket", "backups/b1/b1.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, &objectLabels{
+		Tags:     map[string]string{"team": "platform", "velero.io/backup": "b1"},
+		Metadata: map[string]string{"owner": "platform"},
+	}, labels)
+}
@@ -0,0 +1,213 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// presignedRequest is a request that anyone can send, without credentials,
+// until it expires. Velero can only ask for presigned GET URLs, so these are
+// created by the presign commands, for data movers that read and write the
+// backup location directly.
+type presignedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Header holds the headers that were signed, which must be sent with
+	// the request, e.g. Content-Type or the server-side encryption headers.
+	Header http.Header `json:"header,omitempty"`
+}
+
+// useSignatureVersion makes req sign with the location's signature version.
+func (o *ObjectStore) useSignatureVersion(req *request.Request) {
+	if o.signatureVersion == "1" {
+		req.Handlers.Sign.Remove(v4.SignRequestHandler)
+		req.Handlers.Sign.PushBackNamed(v1SignRequestHandler)
+	}
+}
+
+func (o *ObjectStore) presign(req *request.Request, ttl time.Duration) (*presignedRequest, error) {
+	o.useSignatureVersion(req)
+
+	url, header, err := req.PresignRequest(ttl)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// the V4 signer returns the signed headers with lowercase names
+	signed := http.Header{}
+	for name, values := range header {
+		for _, val := range values {
+			signed.Add(name, val)
+		}
+	}
+
+	return &presignedRequest{
+		Method: req.HTTPRequest.Method,
+		URL:    url,
+		Header: signed,
+	}, nil
+}
+
+// createSignedPutURL presigns a PUT of the object at key. If contentType or
+// contentMD5 (the base64-encoded MD5 of the content) are set, the upload must
+// send them and S3 rejects content that doesn't match contentMD5. The object
+// is encrypted and labeled the same way PutObject encrypts and labels
+// objects, so the upload must send the tagging and metadata headers too.
+func (o *ObjectStore) createSignedPutURL(bucket, key string, ttl time.Duration, contentType, contentMD5 string) (presigned *presignedRequest, err error) {
+	_, span := startSpan("ObjectStore.createSignedPutURL", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CreateSignedPutURL", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("CreateSignedPutURL", bucket, key); err != nil {
+		return nil, err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Metadata: o.objectMetadata(),
+		ACL:      o.objectACL(),
+	}
+	if input.Tagging, err = o.objectTagging(key); err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if contentMD5 != "" {
+		input.ContentMD5 = aws.String(contentMD5)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = o.encryption()
+
+	req, _ := o.preSignS3.PutObjectRequest(input)
+	return o.presign(req, ttl)
+}
+
+// createSignedHeadURL presigns a HEAD of the object at key.
+func (o *ObjectStore) createSignedHeadURL(bucket, key string, ttl time.Duration) (presigned *presignedRequest, err error) {
+	_, span := startSpan("ObjectStore.createSignedHeadURL", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CreateSignedHeadURL", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	req, _ := o.preSignS3.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return o.presign(req, ttl)
+}
+
+// createMultipartUpload starts a multipart upload of the object at key,
+// encrypted and labeled the same way PutObject encrypts and labels objects,
+// whose parts can then be
+// uploaded with URLs from createSignedUploadPartURL.
+func (o *ObjectStore) createMultipartUpload(bucket, key string) (uploadID string, err error) {
+	ctx, span := startSpan("ObjectStore.createMultipartUpload", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CreateMultipartUpload", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("CreateMultipartUpload", bucket, key); err != nil {
+		return "", err
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Metadata: o.objectMetadata(),
+		ACL:      o.objectACL(),
+	}
+	if input.Tagging, err = o.objectTagging(key); err != nil {
+		return "", err
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = o.encryption()
+
+	res, err := o.s3.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", errors.Wrapf(o.explainACLError(err), "error creating multipart upload of object %s", key)
+	}
+
+	return aws.StringValue(res.UploadId), nil
+}
+
+// createSignedUploadPartURL presigns a PUT of part partNumber (1 to 10000)
+// of a multipart upload. If contentMD5 is set, the upload must send it and
+// S3 rejects content that doesn't match it.
+func (o *ObjectStore) createSignedUploadPartURL(bucket, key, uploadID string, partNumber int64, ttl time.Duration, contentMD5 string) (presigned *presignedRequest, err error) {
+	_, span := startSpan("ObjectStore.createSignedUploadPartURL", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CreateSignedUploadPartURL", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("CreateSignedUploadPartURL", bucket, key); err != nil {
+		return nil, err
+	}
+
+	input := &s3.UploadPartInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+	}
+	if contentMD5 != "" {
+		input.ContentMD5 = aws.String(contentMD5)
+	}
+
+	req, _ := o.preSignS3.UploadPartRequest(input)
+	return o.presign(req, ttl)
+}
+
+// completeMultipartUpload completes a multipart upload from the ETags that
+// S3 returned for each uploaded part. If completing fails the upload is
+// aborted so that its parts stop costing storage.
+func (o *ObjectStore) completeMultipartUpload(bucket, key, uploadID string, parts []*s3.CompletedPart) (err error) {
+	ctx, span := startSpan("ObjectStore.completeMultipartUpload", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() {
+		o.audit.record(auditEntry{Operation: "ObjectStore.CompleteMultipartUpload", Bucket: bucket, Key: key}, err)
+		endSpan(span, err)
+	}()
+
+	if err = o.checkWritable("CompleteMultipartUpload", bucket, key); err != nil {
+		return err
+	}
+
+	_, err = o.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		o.abortMultipartUpload(ctx, bucket, key, aws.String(uploadID))
+		return errors.Wrapf(err, "error completing multipart upload of object %s", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPresignObjectStore(t *testing.T, signatureVersion string) *ObjectStore {
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion("us-east-1").
+		WithEndpoint("http://minio:9000").
+		WithS3ForcePathStyle(true).
+		WithCredentials(credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")))
+	require.NoError(t, err)
+
+	return &ObjectStore{
+		log:              newLogger(),
+		preSignS3:        s3.New(sess),
+		signatureVersion: signatureVersion,
+		kmsKeyID:         "my-key",
+	}
+}
+
+// v1Signature computes the expected V1 signature of stringToSign.
+func v1Signature(stringToSign string) string {
+	hash := hmac.New(sha1.New, []byte("secret"))
+	hash.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// v1Expires returns the Expires of a V1 URL presigned with ttl after before,
+// which must be the epoch time the URL expires at.
+func v1Expires(t *testing.T, u *url.URL, before time.Time, ttl time.Duration) string {
+	expires, err := strconv.ParseInt(u.Query().Get("Expires"), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, expires >= before.Add(ttl).Unix() && expires <= time.Now().Add(ttl).Unix(), "Expires %d isn't %s from now", expires, ttl)
+	return u.Query().Get("Expires")
+}
+
+// TestV1SignerPresignAWSExample signs the query string authentication example
+// from https://docs.aws.amazon.com/AmazonS3/latest/userguide/RESTAuthentication.html,
+// whose Expires is the epoch time the URL expires at.
+func TestV1SignerPresignAWSExample(t *testing.T) {
+	expires := time.Unix(1175139620, 0)
+
+	req := newV2Request(t, http.MethodGet, "https://s3.amazonaws.com/johnsmith/photos/puppy.jpg",
+		&s3.GetObjectInput{Bucket: aws.String("johnsmith"), Key: aws.String("photos/puppy.jpg")}, nil, expires.Add(-10*time.Minute))
+	req.ExpireTime = 10 * time.Minute
+
+	signSDKRequest(req)
+	require.NoError(t, req.Error)
+
+	query := req.HTTPRequest.URL.Query()
+	assert.Equal(t, exampleAccessKeyID, query.Get("AWSAccessKeyId"))
+	assert.Equal(t, "1175139620", query.Get("Expires"))
+	assert.Equal(t, "NpgCjnDzrM+WFzoENXmpNDUsSn8=", query.Get("Signature"))
+}
+
+func TestCreateSignedPutURLV4(t *testing.T) {
+	o := newPresignObjectStore(t, "4")
+
+	presigned, err := o.createSignedPutURL("bucket", "restic/data/00", time.Hour, "application/octet-stream", "1B2M2Y8AsgTpgAmY7PhCfg==")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPut, presigned.Method)
+
+	u, err := url.Parse(presigned.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/bucket/restic/data/00", u.Path)
+	assert.Equal(t, "3600", u.Query().Get("X-Amz-Expires"))
+	assert.Equal(t, "content-md5;content-type;host;x-amz-server-side-encryption;x-amz-server-side-encryption-aws-kms-key-id", u.Query().Get("X-Amz-SignedHeaders"))
+
+	assert.Equal(t, "application/octet-stream", presigned.Header.Get("Content-Type"))
+	assert.Equal(t, "1B2M2Y8AsgTpgAmY7PhCfg==", presigned.Header.Get("Content-Md5"))
+	assert.Equal(t, "aws:kms", presigned.Header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "my-key", presigned.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+}
+
+func TestCreateSignedPutURLV1(t *testing.T) {
+	o := newPresignObjectStore(t, "1")
+
+	before := time.Now()
+	presigned, err := o.createSignedPutURL("bucket", "restic/data/00", time.Hour, "application/octet-stream", "1B2M2Y8AsgTpgAmY7PhCfg==")
+	require.NoError(t, err)
+
+	u, err := url.Parse(presigned.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDEXAMPLE", u.Query().Get("AWSAccessKeyId"))
+	expires := v1Expires(t, u, before, time.Hour)
+
+	stringToSign := "PUT\n" +
+		"1B2M2Y8AsgTpgAmY7PhCfg==\n" +
+		"application/octet-stream\n" +
+		expires + "\n" +
+		"x-amz-server-side-encryption:aws:kms\n" +
+		"x-amz-server-side-encryption-aws-kms-key-id:my-key\n" +
+		"/bucket/restic/data/00"
+	assert.Equal(t, v1Signature(stringToSign), u.Query().Get("Signature"))
+
+	assert.Equal(t, "application/octet-stream", presigned.Header.Get("Content-Type"))
+	assert.Equal(t, "1B2M2Y8AsgTpgAmY7PhCfg==", presigned.Header.Get("Content-Md5"))
+	assert.Equal(t, "aws:kms", presigned.Header.Get("X-Amz-Server-Side-Encryption"))
+}
+
+func TestCreateSignedPutURLLabels(t *testing.T) {
+	labels, err := parseObjectLabelOptions(map[string]string{
+		objectTagsKey:         "team=platform",
+		objectTagTemplatesKey: "velero.io/backup={{.BackupName}}",
+		objectMetadataKey:     "owner=platform",
+	}, trashOptions{})
+	require.NoError(t, err)
+
+	for _, signatureVersion := range []string{"1", "4"} {
+		t.Run("V"+signatureVersion, func(t *testing.T) {
+			o := newPresignObjectStore(t, signatureVersion)
+			o.labels = labels
+
+			presigned, err := o.createSignedPutURL("bucket", "backups/b1/b1.tar.gz", time.Hour, "", "")
+			require.NoError(t, err)
+
+			// the labels are signed, so the upload must send them as is
+			tags, err := url.ParseQuery(presigned.Header.Get("X-Amz-Tagging"))
+			require.NoError(t, err)
+			assert.Equal(t, url.Values{"team": {"platform"}, "velero.io/backup": {"b1"}}, tags)
+			assert.Equal(t, "platform", presigned.Header.Get("X-Amz-Meta-Owner"))
+
+			u, err := url.Parse(presigned.URL)
+			require.NoError(t, err)
+			if signatureVersion == "4" {
+				assert.Contains(t, u.Query().Get("X-Amz-SignedHeaders"), "x-amz-meta-owner")
+				assert.Contains(t, u.Query().Get("X-Amz-SignedHeaders"), "x-amz-tagging")
+			}
+		})
+	}
+}
+
+func TestCreateSignedHeadURL(t *testing.T) {
+	o := newPresignObjectStore(t, "1")
+
+	before := time.Now()
+	presigned, err := o.createSignedHeadURL("bucket", "backups/b1/velero-backup.json", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodHead, presigned.Method)
+
+	u, err := url.Parse(presigned.URL)
+	require.NoError(t, err)
+	assert.Equal(t, v1Signature("HEAD\n\n\n"+v1Expires(t, u, before, time.Minute)+"\n/bucket/backups/b1/velero-backup.json"), u.Query().Get("Signature"))
+	assert.Empty(t, presigned.Header)
+}
+
+func TestCreateSignedUploadPartURL(t *testing.T) {
+	o := newPresignObjectStore(t, "1")
+
+	before := time.Now()
+	presigned, err := o.createSignedUploadPartURL("bucket", "restic/data/00", "upload-1", 3, time.Minute, "")
+	require.NoError(t, err)
+
+	u, err := url.Parse(presigned.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "3", u.Query().Get("partNumber"))
+	assert.Equal(t, "upload-1", u.Query().Get("uploadId"))
+
+	// the part number and upload ID are sub-resources, so they're signed
+	assert.Equal(t, v1Signature("PUT\n\n\n"+v1Expires(t, u, before, time.Minute)+"\n/bucket/restic/data/00?partNumber=3&uploadId=upload-1"), u.Query().Get("Signature"))
+
+	o = newPresignObjectStore(t, "4")
+	presigned, err = o.createSignedUploadPartURL("bucket", "restic/data/00", "upload-1", 3, time.Minute, "")
+	require.NoError(t, err)
+	assert.Contains(t, presigned.URL, "X-Amz-Signature=")
+	assert.Contains(t, presigned.URL, "partNumber=3")
+}
+
+func TestV1SignerRejectsUnsupportedMethods(t *testing.T) {
+	o := newPresignObjectStore(t, "1")
+
+	req, _ := o.preSignS3.(*s3.S3).DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+	})
+	o.useSignatureVersion(req)
+
+	_, err := req.Presign(time.Minute)
+	assert.Equal(t, errInvalidMethod, err)
+}
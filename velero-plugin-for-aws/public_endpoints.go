@@ -0,0 +1,102 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	publicEndpointsKey       = "publicEndpoints"
+	defaultPublicEndpointKey = "defaultPublicEndpoint"
+)
+
+// publicEndpoint is one of several named endpoints that the location's
+// signed URLs can be created for, e.g. one inside the VPC and one on the
+// internet. Config values are strings, so the endpoints are configured as a
+// JSON list.
+type publicEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// S3ForcePathStyle defaults to the location's s3ForcePathStyle.
+	S3ForcePathStyle *bool `json:"s3ForcePathStyle,omitempty"`
+	// CACert defaults to the location's caCert.
+	CACert string `json:"caCert,omitempty"`
+}
+
+// parsePublicEndpoints returns the public endpoints that signed URLs can be
+// created for and the name of the default one, or no endpoints if signed
+// URLs are created for publicUrl or s3Url. Velero can't ask for a URL for a
+// particular endpoint, so its signed URLs are for the default one; the
+// presign commands take the name of any of them as a hint.
+func parsePublicEndpoints(config map[string]string) ([]publicEndpoint, string, error) {
+	val := config[publicEndpointsKey]
+	defaultEndpoint := config[defaultPublicEndpointKey]
+
+	if val == "" {
+		if defaultEndpoint != "" {
+			return nil, "", errors.Errorf("%s requires %s", defaultPublicEndpointKey, publicEndpointsKey)
+		}
+		return nil, "", nil
+	}
+	if config[publicURLKey] != "" {
+		return nil, "", errors.Errorf("%s and %s can't both be set", publicURLKey, publicEndpointsKey)
+	}
+	if defaultEndpoint == "" {
+		return nil, "", errors.Errorf("%s requires %s", publicEndpointsKey, defaultPublicEndpointKey)
+	}
+
+	var endpoints []publicEndpoint
+	decoder := json.NewDecoder(bytes.NewReader([]byte(val)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&endpoints); err != nil {
+		return nil, "", errors.Wrapf(err, "could not parse %s (expected JSON list of endpoints)", publicEndpointsKey)
+	}
+
+	names := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.Name == "" || endpoint.URL == "" {
+			return nil, "", errors.Errorf("invalid %s: every endpoint must have a name and url", publicEndpointsKey)
+		}
+		if names[endpoint.Name] {
+			return nil, "", errors.Errorf("invalid %s: duplicate endpoint %q", publicEndpointsKey, endpoint.Name)
+		}
+		names[endpoint.Name] = true
+	}
+
+	if !names[defaultEndpoint] {
+		return nil, "", errors.Errorf("invalid %s: no public endpoint named %q", defaultPublicEndpointKey, defaultEndpoint)
+	}
+
+	return endpoints, defaultEndpoint, nil
+}
+
+// withPublicEndpoint returns a copy of the object store that signs URLs for
+// the public endpoint with the given name rather than the default one.
+func (o *ObjectStore) withPublicEndpoint(name string) (*ObjectStore, error) {
+	client, ok := o.publicEndpoints[name]
+	if !ok {
+		return nil, errors.Errorf("no public endpoint named %q in %s", name, publicEndpointsKey)
+	}
+
+	store := *o
+	store.preSignS3 = client
+	return &store, nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPublicEndpoints = `[
+	{"name": "vpc", "url": "https://s3.internal:9000"},
+	{"name": "corp", "url": "https://s3.corp.example.com", "s3ForcePathStyle": false},
+	{"name": "internet", "url": "https://s3.example.com"}
+]`
+
+func TestParsePublicEndpoints(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          map[string]string
+		expectedNames   []string
+		expectedDefault string
+		expectedErr     string
+	}{
+		{
+			name:   "unset",
+			config: map[string]string{},
+		},
+		{
+			name:        "endpoints without a default",
+			config:      map[string]string{publicEndpointsKey: testPublicEndpoints},
+			expectedErr: "publicEndpoints requires defaultPublicEndpoint",
+		},
+		{
+			name:            "endpoints with a default",
+			config:          map[string]string{publicEndpointsKey: testPublicEndpoints, defaultPublicEndpointKey: "internet"},
+			expectedNames:   []string{"vpc", "corp", "internet"},
+			expectedDefault: "internet",
+		},
+		{
+			name:        "unknown default",
+			config:      map[string]string{publicEndpointsKey: testPublicEndpoints, defaultPublicEndpointKey: "mars"},
+			expectedErr: `invalid defaultPublicEndpoint: no public endpoint named "mars"`,
+		},
+		{
+			name:        "default without endpoints",
+			config:      map[string]string{defaultPublicEndpointKey: "vpc"},
+			expectedErr: "defaultPublicEndpoint requires publicEndpoints",
+		},
+		{
+			name:        "publicUrl and endpoints",
+			config:      map[string]string{publicEndpointsKey: testPublicEndpoints, publicURLKey: "https://s3.example.com"},
+			expectedErr: "publicUrl and publicEndpoints can't both be set",
+		},
+		{
+			name:        "invalid JSON",
+			config:      map[string]string{publicEndpointsKey: "vpc=https://s3.internal", defaultPublicEndpointKey: "vpc"},
+			expectedErr: "could not parse publicEndpoints (expected JSON list of endpoints)",
+		},
+		{
+			name:        "unknown field",
+			config:      map[string]string{publicEndpointsKey: `[{"name": "vpc", "url": "https://s3.internal", "insecure": true}]`, defaultPublicEndpointKey: "vpc"},
+			expectedErr: "could not parse publicEndpoints",
+		},
+		{
+			name:        "missing URL",
+			config:      map[string]string{publicEndpointsKey: `[{"name": "vpc"}]`, defaultPublicEndpointKey: "vpc"},
+			expectedErr: "invalid publicEndpoints: every endpoint must have a name and url",
+		},
+		{
+			name:        "duplicate name",
+			config:      map[string]string{publicEndpointsKey: `[{"name": "vpc", "url": "https://a"}, {"name": "vpc", "url": "https://b"}]`, defaultPublicEndpointKey: "vpc"},
+			expectedErr: `invalid publicEndpoints: duplicate endpoint "vpc"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoints, defaultEndpoint, err := parsePublicEndpoints(tc.config)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var names []string
+			for _, endpoint := range endpoints {
+				names = append(names, endpoint.Name)
+			}
+			assert.Equal(t, tc.expectedNames, names)
+			assert.Equal(t, tc.expectedDefault, defaultEndpoint)
+		})
+	}
+}
+
+func TestCreateSignedURLPublicEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		endpoint     string
+		expectedHost string
+		expectedPath string
+	}{
+		{
+			// without a public endpoint, URLs are for s3Url
+			endpoint:     "",
+			expectedHost: "minio:9000",
+			expectedPath: "/bucket/backups/b1/b1-logs.gz",
+		},
+		{
+			endpoint:     "vpc",
+			expectedHost: "s3.internal:9000",
+			expectedPath: "/bucket/backups/b1/b1-logs.gz",
+		},
+		{
+			// the endpoint overrides the location's s3ForcePathStyle
+			endpoint:     "corp",
+			expectedHost: "bucket.s3.corp.example.com",
+			expectedPath: "/backups/b1/b1-logs.gz",
+		},
+		{
+			endpoint:     "internet",
+			expectedHost: "s3.example.com",
+			expectedPath: "/bucket/backups/b1/b1-logs.gz",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			config := map[string]string{
+				bucketKey:           "bucket",
+				regionKey:           "minio",
+				s3URLKey:            "http://minio:9000",
+				s3ForcePathStyleKey: "true",
+				credentialsFileKey:  writeCredentialsFile(t, dir),
+			}
+			if tc.endpoint != "" {
+				config[publicEndpointsKey] = testPublicEndpoints
+				config[defaultPublicEndpointKey] = tc.endpoint
+			}
+
+			o := newObjectStore(newLogger())
+			require.NoError(t, o.Init(config))
+
+			signed, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+			require.NoError(t, err)
+
+			u, err := url.Parse(signed)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, u.Host)
+			assert.Equal(t, tc.expectedPath, u.Path)
+			assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiRateLimitKey = "apiRateLimit"
+	apiRateBurstKey = "apiRateBurst"
+)
+
+// rateLimiters holds the token buckets shared by every plugin instance in
+// this process, so that concurrent snapshotters for the same account and
+// region draw from a single budget.
+var (
+	rateLimitersLock sync.Mutex
+	rateLimiters     = map[string]*rate.Limiter{}
+)
+
+// rateLimitOptions holds the client-side rate limit for an AWS session. A
+// zero limit disables rate limiting.
+type rateLimitOptions struct {
+	limit float64
+	burst int
+}
+
+// parseRateLimitOptions reads the rate-limit keys from a location's config.
+// The burst defaults to the per-second limit, rounded up.
+func parseRateLimitOptions(config map[string]string) (rateLimitOptions, error) {
+	var (
+		opts rateLimitOptions
+		err  error
+	)
+
+	if val := config[apiRateLimitKey]; val != "" {
+		if opts.limit, err = strconv.ParseFloat(val, 64); err != nil {
+			return rateLimitOptions{}, errors.Wrapf(err, "could not parse %s (expected float)", apiRateLimitKey)
+		}
+		if opts.limit < 0 {
+			return rateLimitOptions{}, errors.Errorf("invalid %s: must not be negative", apiRateLimitKey)
+		}
+	}
+
+	opts.burst = int(math.Ceil(opts.limit))
+
+	if val := config[apiRateBurstKey]; val != "" {
+		if opts.burst, err = strconv.Atoi(val); err != nil {
+			return rateLimitOptions{}, errors.Wrapf(err, "could not parse %s (expected int)", apiRateBurstKey)
+		}
+		if opts.burst < 1 {
+			return rateLimitOptions{}, errors.Errorf("invalid %s: must be at least 1", apiRateBurstKey)
+		}
+	}
+
+	return opts, nil
+}
+
+// getRateLimiter returns the process-wide limiter for the given scope
+// (e.g. region and credentials profile) and options, creating it if needed.
+// It returns nil if rate limiting is disabled.
+func getRateLimiter(scope string, opts rateLimitOptions) *rate.Limiter {
+	if opts.limit == 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s/%g/%d", scope, opts.limit, opts.burst)
+
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+
+	limiter, ok := rateLimiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(opts.limit), opts.burst)
+		rateLimiters[key] = limiter
+	}
+
+	return limiter
+}
+
+// newRateLimitHandler returns a request handler that blocks until the
+// limiter allows the request to be sent. It belongs at the front of the
+// Sign handlers: those run once per attempt, so retries are rate limited
+// too, and unlike the Send handlers an error there stops the request.
+func newRateLimitHandler(limiter *rate.Limiter) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "velero.RateLimitHandler",
+		Fn: func(r *request.Request) {
+			if err := limiter.Wait(r.Context()); err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode, "request canceled while waiting for rate limiter", err)
+			}
+		},
+	}
+}
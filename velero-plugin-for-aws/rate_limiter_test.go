@@ -0,0 +1,108 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestParseRateLimitOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]string
+		expected      rateLimitOptions
+		expectedError string
+	}{
+		{
+			name:     "disabled by default",
+			config:   map[string]string{},
+			expected: rateLimitOptions{},
+		},
+		{
+			name:     "burst defaults to limit rounded up",
+			config:   map[string]string{apiRateLimitKey: "2.5"},
+			expected: rateLimitOptions{limit: 2.5, burst: 3},
+		},
+		{
+			name:     "explicit burst",
+			config:   map[string]string{apiRateLimitKey: "10", apiRateBurstKey: "20"},
+			expected: rateLimitOptions{limit: 10, burst: 20},
+		},
+		{
+			name:          "negative limit",
+			config:        map[string]string{apiRateLimitKey: "-1"},
+			expectedError: "invalid apiRateLimit: must not be negative",
+		},
+		{
+			name:          "zero burst",
+			config:        map[string]string{apiRateLimitKey: "1", apiRateBurstKey: "0"},
+			expectedError: "invalid apiRateBurst: must be at least 1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseRateLimitOptions(tc.config)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestGetRateLimiter(t *testing.T) {
+	assert.Nil(t, getRateLimiter("us-east-1/default", rateLimitOptions{}))
+
+	opts := rateLimitOptions{limit: 5, burst: 5}
+	limiter := getRateLimiter("us-east-1/default", opts)
+	require.NotNil(t, limiter)
+
+	// the same scope and options share a limiter
+	assert.Same(t, limiter, getRateLimiter("us-east-1/default", opts))
+
+	// a different scope gets its own limiter
+	assert.NotSame(t, limiter, getRateLimiter("us-west-2/default", opts))
+}
+
+func TestRateLimitHandlerCanceled(t *testing.T) {
+	// a limiter with an empty bucket and no refill blocks until the context is done
+	limiter := rate.NewLimiter(rate.Limit(0.001), 1)
+	require.True(t, limiter.Allow())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := &request.Request{HTTPRequest: &http.Request{}}
+	req.SetContext(ctx)
+
+	newRateLimitHandler(limiter).Fn(req)
+
+	aerr, ok := req.Error.(awserr.Error)
+	require.True(t, ok)
+	assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+}
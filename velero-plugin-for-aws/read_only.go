@@ -0,0 +1,71 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const readOnlyKey = "readOnly"
+
+// readOnlyError is returned for any write or delete to a read-only
+// location. The plugin rejects them itself, whatever its credentials allow.
+type readOnlyError struct {
+	operation string
+	bucket    string
+	key       string
+}
+
+func (e *readOnlyError) Error() string {
+	if e.key == "" {
+		return fmt.Sprintf("%s rejected: bucket %s is read-only (%s is set)", e.operation, e.bucket, readOnlyKey)
+	}
+	return fmt.Sprintf("%s of object %s rejected: bucket %s is read-only (%s is set)", e.operation, e.key, e.bucket, readOnlyKey)
+}
+
+// isReadOnlyError returns whether err, or an error it wraps, is a
+// *readOnlyError.
+func isReadOnlyError(err error) bool {
+	_, ok := errors.Cause(err).(*readOnlyError)
+	return ok
+}
+
+func parseReadOnly(config map[string]string) (bool, error) {
+	val := config[readOnlyKey]
+	if val == "" {
+		return false, nil
+	}
+
+	readOnly, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, errors.Wrapf(err, "could not parse %s (expected bool)", readOnlyKey)
+	}
+
+	return readOnly, nil
+}
+
+// checkWritable returns a *readOnlyError if the location is read-only. key
+// is empty for operations on more than one object.
+func (o *ObjectStore) checkWritable(operation, bucket, key string) error {
+	if !o.readOnly {
+		return nil
+	}
+	return &readOnlyError{operation: operation, bucket: bucket, key: key}
+}
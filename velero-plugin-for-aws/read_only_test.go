@@ -0,0 +1,123 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnly(t *testing.T) {
+	s3Mock, uploader := new(mockS3), new(mockS3Uploader)
+	o := &ObjectStore{log: newLogger(), s3: s3Mock, preSignS3: s3Mock, s3Uploader: uploader, readOnly: true}
+
+	err := o.PutObject("bucket", "backups/b1/b1.tar.gz", strings.NewReader("contents"))
+	assert.EqualError(t, err, "PutObject of object backups/b1/b1.tar.gz rejected: bucket bucket is read-only (readOnly is set)")
+	assert.True(t, isReadOnlyError(err))
+
+	err = o.DeleteObject("bucket", "backups/b1/b1.tar.gz")
+	assert.EqualError(t, err, "DeleteObject of object backups/b1/b1.tar.gz rejected: bucket bucket is read-only (readOnly is set)")
+	assert.True(t, isReadOnlyError(err))
+
+	err = o.deleteObjects("bucket", []string{"backups/b1/b1.tar.gz", "backups/b1/"})
+	assert.EqualError(t, err, "DeleteObjects rejected: bucket bucket is read-only (readOnly is set)")
+	assert.True(t, isReadOnlyError(err))
+
+	// nothing is sent to S3
+	uploader.AssertNotCalled(t, "UploadWithContext", mock.Anything)
+	s3Mock.AssertNotCalled(t, "DeleteObjectWithContext", mock.Anything)
+	s3Mock.AssertNotCalled(t, "DeleteObjectsWithContext", mock.Anything)
+
+	// reads still work
+	s3Mock.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{}, nil)
+
+	exists, err := o.ObjectExists("bucket", "backups/b1/b1.tar.gz")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.False(t, isReadOnlyError(errors.New("AccessDenied")))
+}
+
+func TestReadOnlySignedURLs(t *testing.T) {
+	o := newPresignObjectStore(t, "4")
+	o.readOnly = true
+
+	// signed GETs and HEADs still work
+	_, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+	assert.NoError(t, err)
+	_, err = o.createSignedHeadURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+	assert.NoError(t, err)
+
+	// but nothing can be uploaded
+	_, err = o.createSignedPutURL("bucket", "backups/b1/b1.tar.gz", time.Minute, "", "")
+	assert.True(t, isReadOnlyError(err))
+	_, err = o.createMultipartUpload("bucket", "backups/b1/b1.tar.gz")
+	assert.True(t, isReadOnlyError(err))
+	_, err = o.createSignedUploadPartURL("bucket", "backups/b1/b1.tar.gz", "upload-id", 1, time.Minute, "")
+	assert.True(t, isReadOnlyError(err))
+	assert.True(t, isReadOnlyError(o.completeMultipartUpload("bucket", "backups/b1/b1.tar.gz", "upload-id", nil)))
+}
+
+func TestParseReadOnly(t *testing.T) {
+	readOnly, err := parseReadOnly(map[string]string{})
+	require.NoError(t, err)
+	assert.False(t, readOnly)
+
+	readOnly, err = parseReadOnly(map[string]string{readOnlyKey: "true"})
+	require.NoError(t, err)
+	assert.True(t, readOnly)
+
+	_, err = parseReadOnly(map[string]string{readOnlyKey: "yes please"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not parse readOnly (expected bool)")
+}
+
+func TestCheckAccessReadOnly(t *testing.T) {
+	s3Mock, uploader := new(mockS3), new(mockS3Uploader)
+	o := &ObjectStore{log: newLogger(), s3: s3Mock, s3Uploader: uploader, readOnly: true}
+
+	s3Mock.On("HeadBucketWithContext", mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+	s3Mock.On("ListObjectsV2PagesWithContext", mock.Anything, mock.Anything).Return(nil)
+
+	// the probe object isn't written
+	require.NoError(t, o.checkAccess("bucket", "prefix"))
+	uploader.AssertNotCalled(t, "UploadWithContext", mock.Anything)
+}
+
+func TestInitReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := newObjectStore(newLogger())
+	require.NoError(t, o.Init(map[string]string{
+		bucketKey:          "bucket",
+		regionKey:          "us-east-1",
+		credentialsFileKey: writeCredentialsFile(t, dir),
+		readOnlyKey:        "true",
+	}))
+	assert.True(t, o.readOnly)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxRetriesKey    = "maxRetries"
+	minRetryDelayKey = "minRetryDelay"
+	maxRetryDelayKey = "maxRetryDelay"
+	retryJitterKey   = "retryJitter"
+)
+
+const defaultRetryJitter = 0.5
+
+// throttleCodes are error codes, beyond the ones the SDK already knows
+// about, that indicate the caller is being rate limited and should back off.
+var throttleCodes = map[string]bool{
+	"RequestLimitExceeded": true, // EC2
+	"SlowDown":             true, // S3
+}
+
+// retryOptions holds the retry and backoff settings for an AWS session.
+type retryOptions struct {
+	maxRetries int
+	minDelay   time.Duration
+	maxDelay   time.Duration
+	jitter     float64
+}
+
+// parseRetryOptions reads the retry-related keys from a location's config,
+// falling back to the SDK's default retryer settings for any that are not set.
+func parseRetryOptions(config map[string]string) (retryOptions, error) {
+	opts := retryOptions{
+		maxRetries: client.DefaultRetryerMaxNumRetries,
+		minDelay:   client.DefaultRetryerMinRetryDelay,
+		maxDelay:   client.DefaultRetryerMaxRetryDelay,
+		jitter:     defaultRetryJitter,
+	}
+
+	var err error
+
+	if val := config[maxRetriesKey]; val != "" {
+		if opts.maxRetries, err = strconv.Atoi(val); err != nil {
+			return retryOptions{}, errors.Wrapf(err, "could not parse %s (expected int)", maxRetriesKey)
+		}
+		if opts.maxRetries < 0 {
+			return retryOptions{}, errors.Errorf("invalid %s: must not be negative", maxRetriesKey)
+		}
+	}
+
+	if val := config[minRetryDelayKey]; val != "" {
+		if opts.minDelay, err = time.ParseDuration(val); err != nil {
+			return retryOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", minRetryDelayKey)
+		}
+	}
+
+	if val := config[maxRetryDelayKey]; val != "" {
+		if opts.maxDelay, err = time.ParseDuration(val); err != nil {
+			return retryOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", maxRetryDelayKey)
+		}
+	}
+
+	if opts.minDelay <= 0 || opts.maxDelay < opts.minDelay {
+		return retryOptions{}, errors.Errorf("invalid retry delays: %s must be positive and no greater than %s", minRetryDelayKey, maxRetryDelayKey)
+	}
+
+	if val := config[retryJitterKey]; val != "" {
+		if opts.jitter, err = strconv.ParseFloat(val, 64); err != nil {
+			return retryOptions{}, errors.Wrapf(err, "could not parse %s (expected float)", retryJitterKey)
+		}
+		if opts.jitter < 0 || opts.jitter > 1 {
+			return retryOptions{}, errors.Errorf("invalid %s: must be between 0 and 1", retryJitterKey)
+		}
+	}
+
+	return opts, nil
+}
+
+// retryer is a request.Retryer that uses capped exponential backoff with
+// jitter, retries throttling and connection resets that the SDK's default
+// retryer gives up on, and logs every retry.
+type retryer struct {
+	log  logrus.FieldLogger
+	opts retryOptions
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newRetryer(opts retryOptions, log logrus.FieldLogger) *retryer {
+	return &retryer{
+		log:  log,
+		opts: opts,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (r *retryer) MaxRetries() int {
+	return r.opts.maxRetries
+}
+
+func (r *retryer) ShouldRetry(req *request.Request) bool {
+	if r.opts.maxRetries == 0 {
+		return false
+	}
+
+	// respect a decision already made by another handler, e.g. for
+	// non-seekable bodies that can't be replayed
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+
+	return isThrottleError(req.Error) || isConnectionReset(req.Error) || req.IsErrorRetryable() || req.IsErrorThrottle()
+}
+
+func (r *retryer) RetryRules(req *request.Request) time.Duration {
+	delay := r.delay(req.RetryCount, isThrottleError(req.Error) || req.IsErrorThrottle())
+
+	fields := logrus.Fields{
+		"operation":  req.Operation.Name,
+		"retryCount": req.RetryCount + 1,
+		"maxRetries": r.opts.maxRetries,
+		"delay":      delay,
+	}
+	if aerr, ok := req.Error.(awserr.Error); ok {
+		fields["code"] = aerr.Code()
+	}
+	r.log.WithFields(fields).WithError(req.Error).Info("Retrying AWS request")
+
+	return delay
+}
+
+// delay returns the backoff before the given retry attempt. Throttled
+// requests wait at least as long as the SDK's default throttle delay.
+func (r *retryer) delay(retryCount int, throttled bool) time.Duration {
+	minDelay := r.opts.minDelay
+	if throttled && minDelay < client.DefaultRetryerMinThrottleDelay {
+		minDelay = client.DefaultRetryerMinThrottleDelay
+	}
+
+	backoff := r.opts.maxDelay
+	// stop doubling well before the shift could overflow
+	if retryCount < 32 {
+		if d := minDelay << uint(retryCount); d > 0 && d < backoff {
+			backoff = d
+		}
+	}
+
+	jitter := time.Duration(float64(backoff) * r.opts.jitter)
+	if jitter <= 0 {
+		return backoff
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return backoff - jitter + time.Duration(r.rand.Int63n(int64(jitter)+1))
+}
+
+func isThrottleError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && throttleCodes[aerr.Code()]
+}
+
+// isConnectionReset returns true for connection resets in either direction.
+// The SDK deliberately doesn't retry "read: connection reset", but S3 and
+// EC2 requests are idempotent so it's safe for us to do so.
+func isConnectionReset(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// awserr.Error includes the message of the error it wraps, so this
+	// also matches resets nested inside SDK errors
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe")
+}
@@ -0,0 +1,156 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]string
+		expected      retryOptions
+		expectedError string
+	}{
+		{
+			name:   "defaults",
+			config: map[string]string{},
+			expected: retryOptions{
+				maxRetries: client.DefaultRetryerMaxNumRetries,
+				minDelay:   client.DefaultRetryerMinRetryDelay,
+				maxDelay:   client.DefaultRetryerMaxRetryDelay,
+				jitter:     defaultRetryJitter,
+			},
+		},
+		{
+			name: "all keys set",
+			config: map[string]string{
+				maxRetriesKey:    "10",
+				minRetryDelayKey: "1s",
+				maxRetryDelayKey: "30s",
+				retryJitterKey:   "0",
+			},
+			expected: retryOptions{
+				maxRetries: 10,
+				minDelay:   time.Second,
+				maxDelay:   30 * time.Second,
+				jitter:     0,
+			},
+		},
+		{
+			name:          "min delay greater than max delay",
+			config:        map[string]string{minRetryDelayKey: "1m", maxRetryDelayKey: "1s"},
+			expectedError: "invalid retry delays: minRetryDelay must be positive and no greater than maxRetryDelay",
+		},
+		{
+			name:          "jitter out of range",
+			config:        map[string]string{retryJitterKey: "1.5"},
+			expectedError: "invalid retryJitter: must be between 0 and 1",
+		},
+		{
+			name:          "negative max retries",
+			config:        map[string]string{maxRetriesKey: "-1"},
+			expectedError: "invalid maxRetries: must not be negative",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseRetryOptions(tc.config)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestRetryerShouldRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable *bool
+		expected  bool
+	}{
+		{
+			name:     "EC2 throttling",
+			err:      awserr.New("RequestLimitExceeded", "slow down", nil),
+			expected: true,
+		},
+		{
+			name:     "S3 throttling",
+			err:      awserr.New("SlowDown", "slow down", nil),
+			expected: true,
+		},
+		{
+			name:     "connection reset on read",
+			err:      awserr.New(request.ErrCodeSerialization, "failed", errors.New("read tcp: read: connection reset by peer")),
+			expected: true,
+		},
+		{
+			name:     "access denied",
+			err:      awserr.New("AccessDenied", "no", nil),
+			expected: false,
+		},
+		{
+			name:      "marked as not retryable",
+			err:       awserr.New("SlowDown", "slow down", nil),
+			retryable: aws.Bool(false),
+			expected:  false,
+		},
+	}
+
+	r := newRetryer(retryOptions{maxRetries: 3, minDelay: time.Millisecond, maxDelay: time.Second}, newLogger())
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{Error: tc.err, Retryable: tc.retryable}
+			assert.Equal(t, tc.expected, r.ShouldRetry(req))
+		})
+	}
+}
+
+func TestRetryerDelay(t *testing.T) {
+	r := newRetryer(retryOptions{maxRetries: 10, minDelay: 100 * time.Millisecond, maxDelay: time.Second}, newLogger())
+
+	// no jitter: plain capped exponential backoff
+	assert.Equal(t, 100*time.Millisecond, r.delay(0, false))
+	assert.Equal(t, 400*time.Millisecond, r.delay(2, false))
+	assert.Equal(t, time.Second, r.delay(5, false))
+	assert.Equal(t, time.Second, r.delay(100, false))
+
+	// throttled requests start at the SDK's throttle delay
+	assert.Equal(t, client.DefaultRetryerMinThrottleDelay, r.delay(0, true))
+
+	r.opts.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := r.delay(2, false)
+		assert.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, "delay %v out of range", d)
+	}
+}
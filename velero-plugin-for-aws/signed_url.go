@@ -0,0 +1,210 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"mime"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const (
+	signedURLMaxTTLKey             = "signedUrlMaxTTL"
+	signedURLContentDispositionKey = "signedUrlContentDisposition"
+	cloudFrontURLKey               = "cloudFrontUrl"
+	cloudFrontKeyPairIDKey         = "cloudFrontKeyPairId"
+	cloudFrontPrivateKeyFileKey    = "cloudFrontPrivateKeyFile"
+	cloudFrontAllowedIPRangeKey    = "cloudFrontAllowedIpRange"
+)
+
+// contentTypes are the content types of the objects Velero downloads with
+// signed URLs, by file extension. Logs and results are gzipped files and
+// everything else is gzipped JSON or a gzipped tarball.
+var contentTypes = map[string]string{
+	".gz":   "application/gzip",
+	".json": "application/json",
+	".tar":  "application/x-tar",
+}
+
+// signedURLOptions control the URLs that CreateSignedURL returns, which the
+// Velero CLI uses to download backups and logs.
+type signedURLOptions struct {
+	// maxTTL caps how long a URL is valid for, whatever Velero asks for.
+	maxTTL time.Duration
+	// contentDisposition makes downloads get the object's file name and
+	// content type rather than a random name.
+	contentDisposition bool
+	// cloudFront signs URLs for a CloudFront distribution in front of the
+	// bucket instead of presigning S3 URLs.
+	cloudFront *cloudFrontSigner
+}
+
+type cloudFrontSigner struct {
+	baseURL *url.URL
+	signer  *sign.URLSigner
+	// allowedIPRange restricts a URL to clients in the CIDR range.
+	allowedIPRange string
+}
+
+func parseSignedURLOptions(config map[string]string) (signedURLOptions, error) {
+	var opts signedURLOptions
+
+	if val := config[signedURLMaxTTLKey]; val != "" {
+		maxTTL, err := time.ParseDuration(val)
+		if err != nil {
+			return signedURLOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", signedURLMaxTTLKey)
+		}
+		if maxTTL <= 0 {
+			return signedURLOptions{}, errors.Errorf("invalid %s: must be positive", signedURLMaxTTLKey)
+		}
+		opts.maxTTL = maxTTL
+	}
+
+	if val := config[signedURLContentDispositionKey]; val != "" {
+		contentDisposition, err := strconv.ParseBool(val)
+		if err != nil {
+			return signedURLOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", signedURLContentDispositionKey)
+		}
+		opts.contentDisposition = contentDisposition
+	}
+
+	cloudFront, err := parseCloudFrontSigner(config)
+	if err != nil {
+		return signedURLOptions{}, err
+	}
+	opts.cloudFront = cloudFront
+
+	return opts, nil
+}
+
+func parseCloudFrontSigner(config map[string]string) (*cloudFrontSigner, error) {
+	var (
+		baseURL        = config[cloudFrontURLKey]
+		keyPairID      = config[cloudFrontKeyPairIDKey]
+		privateKeyFile = config[cloudFrontPrivateKeyFileKey]
+		allowedIPRange = config[cloudFrontAllowedIPRangeKey]
+	)
+
+	if baseURL == "" && keyPairID == "" && privateKeyFile == "" {
+		if allowedIPRange != "" {
+			return nil, errors.Errorf("%s requires %s", cloudFrontAllowedIPRangeKey, cloudFrontURLKey)
+		}
+		return nil, nil
+	}
+	if baseURL == "" || keyPairID == "" || privateKeyFile == "" {
+		return nil, errors.Errorf("%s, %s and %s must all be set to sign URLs with CloudFront", cloudFrontURLKey, cloudFrontKeyPairIDKey, cloudFrontPrivateKeyFileKey)
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", cloudFrontURLKey)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+		return nil, errors.Errorf("invalid %s: must be an http or https URL without a query string", cloudFrontURLKey)
+	}
+
+	if allowedIPRange != "" {
+		if _, _, err := net.ParseCIDR(allowedIPRange); err != nil {
+			return nil, errors.Wrapf(err, "could not parse %s (expected CIDR range)", cloudFrontAllowedIPRangeKey)
+		}
+	}
+
+	privateKey, err := sign.LoadPEMPrivKeyFile(privateKeyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading CloudFront private key from %s", privateKeyFile)
+	}
+
+	return &cloudFrontSigner{
+		baseURL:        u,
+		signer:         sign.NewURLSigner(keyPairID, privateKey),
+		allowedIPRange: allowedIPRange,
+	}, nil
+}
+
+// ttl returns how long a URL that Velero asked to be valid for ttl is
+// actually valid for.
+func (opts signedURLOptions) ttl(ttl time.Duration) time.Duration {
+	if opts.maxTTL > 0 && ttl > opts.maxTTL {
+		return opts.maxTTL
+	}
+	return ttl
+}
+
+// setResponseHeaders sets the Content-Disposition and Content-Type that S3
+// responds to a download of the object at key with.
+func (opts signedURLOptions) setResponseHeaders(input *s3.GetObjectInput, key string) {
+	if !opts.contentDisposition {
+		return
+	}
+
+	name := path.Base(key)
+	input.ResponseContentDisposition = aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+
+	contentType, ok := contentTypes[path.Ext(name)]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	input.ResponseContentType = aws.String(contentType)
+}
+
+// sign returns a URL that downloads the object at key through CloudFront
+// until expires. The distribution's origin must be the location's bucket,
+// and it must forward the query string to S3 for the response headers set
+// on input to apply.
+func (c *cloudFrontSigner) sign(key string, input *s3.GetObjectInput, expires time.Time) (string, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	u.RawPath = ""
+
+	query := url.Values{}
+	if input.ResponseContentDisposition != nil {
+		query.Set("response-content-disposition", *input.ResponseContentDisposition)
+	}
+	if input.ResponseContentType != nil {
+		query.Set("response-content-type", *input.ResponseContentType)
+	}
+	u.RawQuery = query.Encode()
+
+	rawURL := u.String()
+
+	if c.allowedIPRange == "" {
+		signed, err := c.signer.Sign(rawURL, expires)
+		return signed, errors.WithStack(err)
+	}
+
+	signed, err := c.signer.SignWithPolicy(rawURL, &sign.Policy{
+		Statements: []sign.Statement{
+			{
+				Resource: rawURL,
+				Condition: sign.Condition{
+					IPAddress:    &sign.IPAddress{SourceIP: c.allowedIPRange},
+					DateLessThan: sign.NewAWSEpochTime(expires),
+				},
+			},
+		},
+	})
+	return signed, errors.WithStack(err)
+}
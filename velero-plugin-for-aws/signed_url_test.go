@@ -0,0 +1,264 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePrivateKey writes a new RSA private key to a PEM file in dir.
+func writePrivateKey(t *testing.T, dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "private-key.pem")
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+
+	return path
+}
+
+func TestParseSignedURLOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signed-url")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	privateKeyFile := writePrivateKey(t, dir)
+
+	tests := []struct {
+		name        string
+		config      map[string]string
+		expectedErr string
+	}{
+		{
+			name:   "defaults",
+			config: map[string]string{},
+		},
+		{
+			name:   "max TTL and content disposition",
+			config: map[string]string{signedURLMaxTTLKey: "5m", signedURLContentDispositionKey: "true"},
+		},
+		{
+			name:        "invalid max TTL",
+			config:      map[string]string{signedURLMaxTTLKey: "soon"},
+			expectedErr: "could not parse signedUrlMaxTTL (expected duration)",
+		},
+		{
+			name:        "zero max TTL",
+			config:      map[string]string{signedURLMaxTTLKey: "0s"},
+			expectedErr: "invalid signedUrlMaxTTL: must be positive",
+		},
+		{
+			name:        "invalid content disposition",
+			config:      map[string]string{signedURLContentDispositionKey: "yes please"},
+			expectedErr: "could not parse signedUrlContentDisposition (expected bool)",
+		},
+		{
+			name: "CloudFront",
+			config: map[string]string{
+				cloudFrontURLKey:            "https://d111111abcdef8.cloudfront.net",
+				cloudFrontKeyPairIDKey:      "APKAEXAMPLE",
+				cloudFrontPrivateKeyFileKey: privateKeyFile,
+				cloudFrontAllowedIPRangeKey: "192.0.2.0/24",
+			},
+		},
+		{
+			name:        "CloudFront without a private key",
+			config:      map[string]string{cloudFrontURLKey: "https://d111111abcdef8.cloudfront.net", cloudFrontKeyPairIDKey: "APKAEXAMPLE"},
+			expectedErr: "cloudFrontUrl, cloudFrontKeyPairId and cloudFrontPrivateKeyFile must all be set to sign URLs with CloudFront",
+		},
+		{
+			name:        "IP range without CloudFront",
+			config:      map[string]string{cloudFrontAllowedIPRangeKey: "192.0.2.0/24"},
+			expectedErr: "cloudFrontAllowedIpRange requires cloudFrontUrl",
+		},
+		{
+			name: "invalid CloudFront URL",
+			config: map[string]string{
+				cloudFrontURLKey:            "d111111abcdef8.cloudfront.net",
+				cloudFrontKeyPairIDKey:      "APKAEXAMPLE",
+				cloudFrontPrivateKeyFileKey: privateKeyFile,
+			},
+			expectedErr: "invalid cloudFrontUrl: must be an http or https URL without a query string",
+		},
+		{
+			name: "invalid IP range",
+			config: map[string]string{
+				cloudFrontURLKey:            "https://d111111abcdef8.cloudfront.net",
+				cloudFrontKeyPairIDKey:      "APKAEXAMPLE",
+				cloudFrontPrivateKeyFileKey: privateKeyFile,
+				cloudFrontAllowedIPRangeKey: "192.0.2.1",
+			},
+			expectedErr: "could not parse cloudFrontAllowedIpRange (expected CIDR range)",
+		},
+		{
+			name: "missing private key file",
+			config: map[string]string{
+				cloudFrontURLKey:            "https://d111111abcdef8.cloudfront.net",
+				cloudFrontKeyPairIDKey:      "APKAEXAMPLE",
+				cloudFrontPrivateKeyFileKey: filepath.Join(dir, "missing.pem"),
+			},
+			expectedErr: "error loading CloudFront private key",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSignedURLOptions(tc.config)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestCreateSignedURLMaxTTL(t *testing.T) {
+	o := newPresignObjectStore(t, "4")
+	o.signedURLs.maxTTL = 5 * time.Minute
+
+	signed, err := o.CreateSignedURL("bucket", "backups/b1/b1.tar.gz", time.Hour)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "300", u.Query().Get("X-Amz-Expires"))
+
+	// shorter TTLs are left alone
+	signed, err = o.CreateSignedURL("bucket", "backups/b1/b1.tar.gz", time.Minute)
+	require.NoError(t, err)
+
+	u, err = url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+}
+
+func TestCreateSignedURLContentDisposition(t *testing.T) {
+	tests := []struct {
+		key                 string
+		expectedDisposition string
+		expectedType        string
+	}{
+		{
+			key:                 "backups/b1/b1-logs.gz",
+			expectedDisposition: "attachment; filename=b1-logs.gz",
+			expectedType:        "application/gzip",
+		},
+		{
+			key:                 "backups/b1/velero-backup.json",
+			expectedDisposition: "attachment; filename=velero-backup.json",
+			expectedType:        "application/json",
+		},
+		{
+			key:                 "restores/r1/restore-r1 results",
+			expectedDisposition: `attachment; filename="restore-r1 results"`,
+			expectedType:        "application/octet-stream",
+		},
+	}
+
+	o := newPresignObjectStore(t, "4")
+	o.signedURLs.contentDisposition = true
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			signed, err := o.CreateSignedURL("bucket", tc.key, time.Minute)
+			require.NoError(t, err)
+
+			u, err := url.Parse(signed)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedDisposition, u.Query().Get("response-content-disposition"))
+			assert.Equal(t, tc.expectedType, u.Query().Get("response-content-type"))
+		})
+	}
+
+	// the response headers aren't overridden by default
+	o.signedURLs.contentDisposition = false
+	signed, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Minute)
+	require.NoError(t, err)
+	assert.NotContains(t, signed, "response-content-disposition")
+}
+
+func TestCreateSignedURLCloudFront(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signed-url")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := map[string]string{
+		cloudFrontURLKey:               "https://d111111abcdef8.cloudfront.net/velero/",
+		cloudFrontKeyPairIDKey:         "APKAEXAMPLE",
+		cloudFrontPrivateKeyFileKey:    writePrivateKey(t, dir),
+		signedURLContentDispositionKey: "true",
+	}
+
+	o := newPresignObjectStore(t, "4")
+	o.signedURLs, err = parseSignedURLOptions(config)
+	require.NoError(t, err)
+
+	before := time.Now()
+	signed, err := o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Hour)
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "d111111abcdef8.cloudfront.net", u.Host)
+	assert.Equal(t, "/velero/backups/b1/b1-logs.gz", u.Path)
+	assert.Equal(t, "APKAEXAMPLE", u.Query().Get("Key-Pair-Id"))
+	assert.NotEmpty(t, u.Query().Get("Signature"))
+	assert.Equal(t, "attachment; filename=b1-logs.gz", u.Query().Get("response-content-disposition"))
+
+	expires, err := strconv.ParseInt(u.Query().Get("Expires"), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, expires >= before.Add(time.Hour).Unix())
+	assert.Empty(t, u.Query().Get("X-Amz-Signature"))
+
+	// an IP range needs a custom policy
+	config[cloudFrontAllowedIPRangeKey] = "192.0.2.0/24"
+	o.signedURLs, err = parseSignedURLOptions(config)
+	require.NoError(t, err)
+
+	signed, err = o.CreateSignedURL("bucket", "backups/b1/b1-logs.gz", time.Hour)
+	require.NoError(t, err)
+
+	u, err = url.Parse(signed)
+	require.NoError(t, err)
+	assert.Empty(t, u.Query().Get("Expires"))
+
+	// CloudFront uses a URL-safe variant of base64
+	encoded := strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(u.Query().Get("Policy"))
+	policy, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	assert.Contains(t, string(policy), `"AWS:SourceIp":"192.0.2.0/24"`)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingEndpointKey = "tracingEndpoint"
+	tracingInsecureKey = "tracingInsecure"
+)
+
+const tracerName = "github.com/vmware-tanzu/velero-plugin-for-aws"
+
+// span attribute keys
+const (
+	bucketAttribute     = attribute.Key("velero.bucket")
+	keyAttribute        = attribute.Key("velero.key")
+	volumeIDAttribute   = attribute.Key("velero.volume_id")
+	snapshotIDAttribute = attribute.Key("velero.snapshot_id")
+
+	awsServiceAttribute    = attribute.Key("aws.service")
+	awsOperationAttribute  = attribute.Key("aws.operation")
+	awsRequestIDAttribute  = attribute.Key("aws.request_id")
+	awsRetryCountAttribute = attribute.Key("aws.retry_count")
+)
+
+// tracingEndpoint is the OTLP endpoint spans are being exported to, if any.
+// The tracer provider is global, so only the first location that configures
+// tracing determines where spans go.
+var (
+	tracingLock     sync.Mutex
+	tracingEndpoint string
+)
+
+// startTracing exports the plugin's spans to the OTLP/HTTP collector at
+// endpoint, e.g. "otel-collector:4318".
+func startTracing(endpoint string, insecure bool, log logrus.FieldLogger) error {
+	tracingLock.Lock()
+	defer tracingLock.Unlock()
+
+	if tracingEndpoint != "" {
+		if endpoint != tracingEndpoint {
+			log.Warnf("Ignoring %s %s: spans are already being exported to %s", tracingEndpointKey, endpoint, tracingEndpoint)
+		}
+		return nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating trace exporter for %s", endpoint)
+	}
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("velero-plugin-for-aws"),
+		)),
+	))
+
+	tracingEndpoint = endpoint
+	log.Infof("Exporting traces to %s", endpoint)
+
+	return nil
+}
+
+// initTracing starts tracing if the location's config asks for it.
+func initTracing(config map[string]string, log logrus.FieldLogger) error {
+	endpoint := config[tracingEndpointKey]
+	if endpoint == "" {
+		return nil
+	}
+
+	var insecure bool
+	if val := config[tracingInsecureKey]; val != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(val); err != nil {
+			return errors.Wrapf(err, "could not parse %s (expected bool)", tracingInsecureKey)
+		}
+	}
+
+	return startTracing(endpoint, insecure, log)
+}
+
+// startSpan starts a span for a plugin operation. AWS requests made with the
+// returned context are recorded as children of the span. Until tracing is
+// started, the global tracer provider is a no-op.
+func startSpan(name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(context.Background(), name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type awsRequestSpanKey struct{}
+
+// awsRequestStartSpanHandler starts a span for an AWS request as a child of
+// the span in the request's context. It runs as a Validate handler, which
+// the SDK only runs once per request no matter how many times it's retried.
+var awsRequestStartSpanHandler = request.NamedHandler{
+	Name: "velero.AWSRequestStartSpanHandler",
+	Fn: func(r *request.Request) {
+		// presigning builds a request that's never sent, so there is
+		// nothing to trace
+		if r.ExpireTime > 0 {
+			return
+		}
+
+		ctx, span := otel.Tracer(tracerName).Start(r.Context(), r.ClientInfo.ServiceName+"."+r.Operation.Name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				awsServiceAttribute.String(r.ClientInfo.ServiceName),
+				awsOperationAttribute.String(r.Operation.Name),
+			),
+		)
+		r.SetContext(context.WithValue(ctx, awsRequestSpanKey{}, span))
+	},
+}
+
+// awsRequestEndSpanHandler ends the span started by awsRequestStartSpanHandler
+// once the request, including any retries, is complete.
+var awsRequestEndSpanHandler = request.NamedHandler{
+	Name: "velero.AWSRequestEndSpanHandler",
+	Fn: func(r *request.Request) {
+		span, ok := r.Context().Value(awsRequestSpanKey{}).(trace.Span)
+		if !ok {
+			return
+		}
+
+		span.SetAttributes(
+			awsRequestIDAttribute.String(r.RequestID),
+			awsRetryCountAttribute.Int(r.RetryCount),
+		)
+		if r.HTTPResponse != nil {
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(r.HTTPResponse.StatusCode))
+		}
+
+		endSpan(span, r.Error)
+	},
+}
@@ -0,0 +1,74 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDeleteObjectTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amz-request-id", "request-1")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sess, err := getSession(session.Options{Config: *aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithEndpoint(server.URL).
+		WithS3ForcePathStyle(true),
+	})
+	require.NoError(t, err)
+
+	o := &ObjectStore{log: newLogger(), s3: s3.New(sess)}
+	require.NoError(t, o.DeleteObject("bucket", "key"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	// the AWS request span ends first
+	request, operation := spans[0], spans[1]
+
+	assert.Equal(t, "ObjectStore.DeleteObject", operation.Name())
+	assert.Contains(t, operation.Attributes(), bucketAttribute.String("bucket"))
+	assert.Contains(t, operation.Attributes(), keyAttribute.String("key"))
+
+	assert.Equal(t, "s3.DeleteObject", request.Name())
+	assert.Equal(t, operation.SpanContext().SpanID(), request.Parent().SpanID())
+	assert.Contains(t, request.Attributes(), awsRequestIDAttribute.String("request-1"))
+	assert.Contains(t, request.Attributes(), awsRetryCountAttribute.Int(0))
+	assert.Contains(t, request.Attributes(), attribute.Int("http.status_code", http.StatusNoContent))
+}
@@ -0,0 +1,187 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	connectTimeoutKey        = "connectTimeout"
+	responseHeaderTimeoutKey = "responseHeaderTimeout"
+	socketTimeoutKey         = "socketTimeout"
+	maxIdleConnsKey          = "maxIdleConns"
+	enableHTTP2Key           = "enableHTTP2"
+)
+
+// defaults mirror the values used by net/http's DefaultTransport so that
+// a location without any transport config behaves as it did before, except
+// for the response header and socket timeouts, which DefaultTransport doesn't
+// have, so a stalled request would hang forever.
+const (
+	defaultResponseHeaderTimeout = 2 * time.Minute
+	defaultSocketTimeout         = 5 * time.Minute
+	defaultConnectTimeout        = 30 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultMaxIdleConns          = 100
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinue        = 1 * time.Second
+)
+
+// transportOptions holds the HTTP transport settings shared by every
+// AWS session the plugin creates.
+type transportOptions struct {
+	connectTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+	socketTimeout         time.Duration
+	maxIdleConns          int
+	enableHTTP2           bool
+	insecureSkipTLSVerify bool
+}
+
+// parseTransportOptions reads the transport-related keys from a location's
+// config, falling back to net/http's defaults for any that are not set.
+func parseTransportOptions(config map[string]string) (transportOptions, error) {
+	opts := transportOptions{
+		connectTimeout:        defaultConnectTimeout,
+		responseHeaderTimeout: defaultResponseHeaderTimeout,
+		socketTimeout:         defaultSocketTimeout,
+		maxIdleConns:          defaultMaxIdleConns,
+		enableHTTP2:           true,
+	}
+
+	var err error
+
+	if val := config[connectTimeoutKey]; val != "" {
+		if opts.connectTimeout, err = time.ParseDuration(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", connectTimeoutKey)
+		}
+	}
+
+	if val := config[responseHeaderTimeoutKey]; val != "" {
+		if opts.responseHeaderTimeout, err = time.ParseDuration(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", responseHeaderTimeoutKey)
+		}
+		if opts.responseHeaderTimeout < 0 {
+			return transportOptions{}, errors.Errorf("invalid %s: must not be negative", responseHeaderTimeoutKey)
+		}
+	}
+
+	if val := config[socketTimeoutKey]; val != "" {
+		if opts.socketTimeout, err = time.ParseDuration(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", socketTimeoutKey)
+		}
+		if opts.socketTimeout < 0 {
+			return transportOptions{}, errors.Errorf("invalid %s: must not be negative", socketTimeoutKey)
+		}
+	}
+
+	if val := config[maxIdleConnsKey]; val != "" {
+		if opts.maxIdleConns, err = strconv.Atoi(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected int)", maxIdleConnsKey)
+		}
+		// net/http doesn't limit idle connections at all if this is 0
+		if opts.maxIdleConns <= 0 {
+			return transportOptions{}, errors.Errorf("invalid %s: must be positive", maxIdleConnsKey)
+		}
+	}
+
+	if val := config[enableHTTP2Key]; val != "" {
+		if opts.enableHTTP2, err = strconv.ParseBool(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", enableHTTP2Key)
+		}
+	}
+
+	if val := config[insecureSkipTLSVerifyKey]; val != "" {
+		if opts.insecureSkipTLSVerify, err = strconv.ParseBool(val); err != nil {
+			return transportOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", insecureSkipTLSVerifyKey)
+		}
+	}
+
+	return opts, nil
+}
+
+// newHTTPClient builds an HTTP client for an AWS session. A new transport is
+// returned on every call because the SDK mutates the transport's TLS config
+// when a custom CA bundle is provided.
+func newHTTPClient(opts transportOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   opts.connectTimeout,
+		KeepAlive: defaultKeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil || opts.socketTimeout == 0 {
+				return conn, err
+			}
+			return &socketTimeoutConn{Conn: conn, timeout: opts.socketTimeout}, nil
+		},
+		// every request of a location goes to the same host, so the pool
+		// size applies to it rather than net/http's default of 2
+		MaxIdleConns:          opts.maxIdleConns,
+		MaxIdleConnsPerHost:   opts.maxIdleConns,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultExpectContinue,
+		ResponseHeaderTimeout: opts.responseHeaderTimeout,
+		ForceAttemptHTTP2:     opts.enableHTTP2,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: opts.insecureSkipTLSVerify,
+		},
+	}
+
+	if !opts.enableHTTP2 {
+		// a non-nil, empty map disables net/http's automatic HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// socketTimeoutConn fails a read or write once the connection has neither
+// sent nor received any data for timeout, e.g. because an upload stalled.
+// Progress in either direction extends the deadline of both, so that the
+// response of a long upload can still be read.
+type socketTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *socketTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *socketTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTransportOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]string
+		expected      transportOptions
+		expectedError string
+	}{
+		{
+			name:   "defaults",
+			config: map[string]string{},
+			expected: transportOptions{
+				connectTimeout:        defaultConnectTimeout,
+				responseHeaderTimeout: defaultResponseHeaderTimeout,
+				socketTimeout:         defaultSocketTimeout,
+				maxIdleConns:          defaultMaxIdleConns,
+				enableHTTP2:           true,
+			},
+		},
+		{
+			name: "all keys set",
+			config: map[string]string{
+				connectTimeoutKey:        "5s",
+				responseHeaderTimeoutKey: "1m",
+				socketTimeoutKey:         "0s",
+				maxIdleConnsKey:          "20",
+				enableHTTP2Key:           "false",
+				insecureSkipTLSVerifyKey: "true",
+			},
+			expected: transportOptions{
+				connectTimeout:        5 * time.Second,
+				responseHeaderTimeout: time.Minute,
+				maxIdleConns:          20,
+				enableHTTP2:           false,
+				insecureSkipTLSVerify: true,
+			},
+		},
+		{
+			name:          "invalid duration",
+			config:        map[string]string{connectTimeoutKey: "5"},
+			expectedError: "could not parse connectTimeout (expected duration): time: missing unit in duration \"5\"",
+		},
+		{
+			name:          "invalid int",
+			config:        map[string]string{maxIdleConnsKey: "lots"},
+			expectedError: "could not parse maxIdleConns (expected int): strconv.Atoi: parsing \"lots\": invalid syntax",
+		},
+		{
+			name:          "no idle connections",
+			config:        map[string]string{maxIdleConnsKey: "0"},
+			expectedError: "invalid maxIdleConns: must be positive",
+		},
+		{
+			name:          "negative duration",
+			config:        map[string]string{socketTimeoutKey: "-1m"},
+			expectedError: "invalid socketTimeout: must not be negative",
+		},
+		{
+			name:          "invalid bool",
+			config:        map[string]string{enableHTTP2Key: "maybe"},
+			expectedError: "could not parse enableHTTP2 (expected bool): strconv.ParseBool: parsing \"maybe\": invalid syntax",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseTransportOptions(tc.config)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	client := newHTTPClient(transportOptions{
+		responseHeaderTimeout: time.Minute,
+		maxIdleConns:          10,
+		enableHTTP2:           false,
+		insecureSkipTLSVerify: true,
+	})
+
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+
+	assert.Equal(t, time.Minute, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 10, transport.MaxIdleConnsPerHost)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+	// each call must return a distinct transport
+	assert.NotSame(t, transport, newHTTPClient(transportOptions{}).Transport)
+}
+
+func TestNewHTTPClientSocketTimeout(t *testing.T) {
+	// the server accepts the upload's connection but never reads from it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(10 * time.Second)
+		}
+	}()
+
+	client := newHTTPClient(transportOptions{
+		connectTimeout: time.Second,
+		socketTimeout:  100 * time.Millisecond,
+		maxIdleConns:   1,
+	})
+
+	body := bytes.NewReader(make([]byte, 64*1024*1024))
+	start := time.Now()
+	_, err = client.Post("http://"+listener.Addr().String()+"/bucket/key", "application/octet-stream", body)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "i/o timeout")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
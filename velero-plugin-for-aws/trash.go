@@ -0,0 +1,351 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	softDeleteKey     = "softDelete"
+	trashRetentionKey = "trashRetention"
+)
+
+// trashDir is where soft-deleted objects are moved, relative to the
+// location's prefix. Velero rejects unknown top-level directories under the
+// prefix but never looks inside plugins/, so trashed objects don't affect
+// the location's validity or backup sync.
+const trashDir = "plugins/aws/trash"
+
+// trashTimestampFormat is the format of the directory a deleted object is
+// moved to under trashDir, which records when it was deleted.
+const trashTimestampFormat = "20060102T150405Z"
+
+// trashedAtTag is set on every trashed object so that a lifecycle rule can
+// expire trashed objects instead of the plugin purging them.
+const trashedAtTag = "velero.io/trashed-at"
+
+// maxCopyObjectSize is the largest object that can be copied with a single
+// CopyObject request. Larger objects are copied in parts of copyPartSize.
+const (
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	copyPartSize      = 512 * 1024 * 1024
+)
+
+// trashPurgeInterval is how often the trash of a location is purged.
+const trashPurgeInterval = time.Hour
+
+type trashOptions struct {
+	enabled   bool
+	retention time.Duration
+}
+
+func parseTrashOptions(config map[string]string) (trashOptions, error) {
+	var opts trashOptions
+
+	if val := config[softDeleteKey]; val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return trashOptions{}, errors.Wrapf(err, "could not parse %s (expected bool)", softDeleteKey)
+		}
+		opts.enabled = enabled
+	}
+
+	if val := config[trashRetentionKey]; val != "" {
+		retention, err := time.ParseDuration(val)
+		if err != nil {
+			return trashOptions{}, errors.Wrapf(err, "could not parse %s (expected duration)", trashRetentionKey)
+		}
+		if retention < 0 {
+			return trashOptions{}, errors.Errorf("invalid %s: must not be negative", trashRetentionKey)
+		}
+		opts.retention = retention
+	}
+
+	return opts, nil
+}
+
+// trashPrefix returns the prefix all trashed objects of the location are under.
+func (o *ObjectStore) trashPrefix() string {
+	return path.Join(o.prefix, trashDir) + "/"
+}
+
+// trashKey returns the key that the object at key is moved to when it's
+// deleted at the given time. The key keeps its path relative to the
+// location's prefix so that it can be restored by moving it back.
+func (o *ObjectStore) trashKey(key string, deletedAt time.Time) string {
+	rel := key
+	if o.prefix != "" {
+		rel = strings.TrimPrefix(key, strings.TrimSuffix(o.prefix, "/")+"/")
+	}
+	return path.Join(o.trashPrefix(), deletedAt.UTC().Format(trashTimestampFormat), rel)
+}
+
+// moveToTrash copies the object at key into the trash, tagged with the time
+// it was deleted as well as the location's tags, and then deletes the
+// original. It returns the object's key in the trash, which is empty if
+// there was no object to trash: like DeleteObject, deleting a missing object
+// succeeds, so that deletes can be retried and mirrored.
+func (o *ObjectStore) moveToTrash(ctx aws.Context, bucket, key string) (string, error) {
+	now := time.Now()
+	trashKey := o.trashKey(key, now)
+
+	tags, err := o.objectTags(key)
+	if err != nil {
+		return "", err
+	}
+	tags.Set(trashedAtTag, now.UTC().Format(time.RFC3339))
+	tagging := tags.Encode()
+
+	head, err := o.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if errorCode(err) == notFoundCode {
+		o.log.WithFields(logrus.Fields{
+			"bucket": bucket,
+			"key":    key,
+		}).Debug("Object to move to trash doesn't exist")
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting size of object %s to move to trash", key)
+	}
+
+	if size := aws.Int64Value(head.ContentLength); size > maxCopyObjectSize {
+		err = o.copyObjectInParts(ctx, bucket, key, trashKey, size, tagging, head.Metadata)
+	} else {
+		err = o.copyObject(ctx, bucket, key, trashKey, tagging)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error moving object %s to trash", key)
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"bucket":   bucket,
+		"key":      key,
+		"trashKey": trashKey,
+	}).Info("Moved deleted object to trash")
+
+	return trashKey, o.removeObject(ctx, bucket, key)
+}
+
+// copySource returns the URL-encoded CopySource of the object at key.
+func copySource(bucket, key string) string {
+	// objects are copied from an access point by its ARN
+	if arn.IsARN(bucket) {
+		return (&url.URL{Path: bucket + "/object/" + key}).EscapedPath()
+	}
+	return (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+}
+
+func (o *ObjectStore) copyObject(ctx aws.Context, bucket, key, destKey, tagging string) error {
+	req := &s3.CopyObjectInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(destKey),
+		CopySource:       aws.String(copySource(bucket, key)),
+		Tagging:          aws.String(tagging),
+		TaggingDirective: aws.String(s3.TaggingDirectiveReplace),
+		ACL:              o.objectACL(),
+	}
+
+	// the copy is encrypted the same way PutObject encrypts new objects
+	req.ServerSideEncryption, req.SSEKMSKeyId = o.encryption()
+
+	_, err := o.s3.CopyObjectWithContext(ctx, req)
+	return errors.WithStack(err)
+}
+
+// copyObjectInParts copies an object that's too large for CopyObject with a
+// multipart upload, aborting the upload if any part fails. Unlike CopyObject
+// it doesn't copy the object's metadata, so that's passed in.
+func (o *ObjectStore) copyObjectInParts(ctx aws.Context, bucket, key, destKey string, size int64, tagging string, metadata map[string]*string) error {
+	createReq := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(destKey),
+		Tagging:  aws.String(tagging),
+		Metadata: metadata,
+		ACL:      o.objectACL(),
+	}
+
+	createReq.ServerSideEncryption, createReq.SSEKMSKeyId = o.encryption()
+
+	upload, err := o.s3.CreateMultipartUploadWithContext(ctx, createReq)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var parts []*s3.CompletedPart
+	for start, partNumber := int64(0), int64(1); start < size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := start + copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		res, err := o.s3.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(destKey),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      aws.String(copySource(bucket, key)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			o.abortMultipartUpload(ctx, bucket, destKey, upload.UploadId)
+			return errors.WithStack(err)
+		}
+
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       res.CopyPartResult.ETag,
+			PartNumber: aws.Int64(partNumber),
+		})
+	}
+
+	_, err = o.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(destKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		o.abortMultipartUpload(ctx, bucket, destKey, upload.UploadId)
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (o *ObjectStore) abortMultipartUpload(ctx aws.Context, bucket, key string, uploadID *string) {
+	_, err := o.s3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		o.log.WithError(err).WithField("key", key).Warn("Error aborting multipart copy; its parts must be cleaned up by a lifecycle rule")
+	}
+}
+
+// purgeTrash permanently deletes the objects that were moved to the trash
+// at least the retention period before now. Objects that can't be deleted
+// are logged and left for the next purge.
+func (o *ObjectStore) purgeTrash(bucket string, now time.Time) (err error) {
+	ctx, span := startSpan("ObjectStore.purgeTrash", bucketAttribute.String(bucket), keyAttribute.String(o.trashPrefix()))
+	defer func() { endSpan(span, err) }()
+
+	log := o.log.WithField("bucket", bucket)
+
+	var expired []string
+	err = o.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(o.trashPrefix()),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, prefix := range page.CommonPrefixes {
+			dir := aws.StringValue(prefix.Prefix)
+			deletedAt, err := time.Parse(trashTimestampFormat, path.Base(dir))
+			if err != nil {
+				log.WithField("prefix", dir).Warn("Ignoring trash directory that isn't named for the time its objects were deleted")
+				continue
+			}
+			if now.Sub(deletedAt) >= o.trash.retention {
+				expired = append(expired, dir)
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return errors.Wrap(err, "error listing trash")
+	}
+
+	var failed int
+	for _, dir := range expired {
+		var keys []string
+		err := o.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(dir),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				keys = append(keys, aws.StringValue(obj.Key))
+			}
+			return !lastPage
+		})
+		if err != nil {
+			log.WithError(err).WithField("prefix", dir).Error("Error listing trashed objects")
+			failed++
+			continue
+		}
+
+		for _, key := range keys {
+			err := o.removeObject(ctx, bucket, key)
+			o.audit.record(auditEntry{Operation: "ObjectStore.PurgeTrash", Bucket: bucket, Key: key}, err)
+			if err != nil {
+				log.WithError(err).WithField("key", key).Error("Error purging trashed object")
+				failed++
+			}
+		}
+
+		log.WithField("prefix", dir).Infof("Purged %d trashed objects", len(keys))
+	}
+
+	if failed > 0 {
+		return errors.Errorf("failed to purge %d trashed objects or directories", failed)
+	}
+
+	return nil
+}
+
+// trashPurges records when each location's trash was last purged. Velero
+// initializes a new object store for most operations, so without this every
+// Init would list the trash.
+var (
+	trashPurgesLock sync.Mutex
+	trashPurges     = map[string]time.Time{}
+)
+
+// startTrashPurge purges the trash in the background, unless it was purged
+// within the last trashPurgeInterval.
+func (o *ObjectStore) startTrashPurge(bucket string) {
+	now := time.Now()
+	location := bucket + "/" + o.prefix
+
+	trashPurgesLock.Lock()
+	if last, ok := trashPurges[location]; ok && now.Sub(last) < trashPurgeInterval {
+		trashPurgesLock.Unlock()
+		return
+	}
+	trashPurges[location] = now
+	trashPurgesLock.Unlock()
+
+	go func() {
+		if err := o.purgeTrash(bucket, now); err != nil {
+			o.log.WithError(err).Error("Error purging trash")
+		}
+	}()
+}
@@ -0,0 +1,247 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrashOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		expected    trashOptions
+		expectedErr string
+	}{
+		{
+			name:     "defaults",
+			config:   map[string]string{},
+			expected: trashOptions{},
+		},
+		{
+			name:     "soft delete with retention",
+			config:   map[string]string{softDeleteKey: "true", trashRetentionKey: "720h"},
+			expected: trashOptions{enabled: true, retention: 720 * time.Hour},
+		},
+		{
+			name:        "invalid soft delete",
+			config:      map[string]string{softDeleteKey: "yes please"},
+			expectedErr: "could not parse softDelete (expected bool)",
+		},
+		{
+			name:        "invalid retention",
+			config:      map[string]string{trashRetentionKey: "30d"},
+			expectedErr: "could not parse trashRetention (expected duration)",
+		},
+		{
+			name:        "negative retention",
+			config:      map[string]string{trashRetentionKey: "-1h"},
+			expectedErr: "invalid trashRetention: must not be negative",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseTrashOptions(tc.config)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestTrashKey(t *testing.T) {
+	deletedAt := time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	o := &ObjectStore{}
+	assert.Equal(t, "plugins/aws/trash/20200501T123000Z/backups/b1/b1.tar.gz", o.trashKey("backups/b1/b1.tar.gz", deletedAt))
+
+	o = &ObjectStore{prefix: "cluster-a"}
+	assert.Equal(t, "cluster-a/plugins/aws/trash/20200501T123000Z/backups/b1/b1.tar.gz", o.trashKey("cluster-a/backups/b1/b1.tar.gz", deletedAt))
+}
+
+func TestSoftDeleteObject(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{
+		log:      newLogger(),
+		s3:       s,
+		prefix:   "cluster-a",
+		trash:    trashOptions{enabled: true},
+		kmsKeyID: "my-key",
+	}
+
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(1024)}, nil)
+	s.On("CopyObjectWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*s3.CopyObjectInput)
+		assert.Regexp(t, `^cluster-a/plugins/aws/trash/\d{8}T\d{6}Z/backups/b 1/velero-backup.json$`, *input.Key)
+		assert.Equal(t, "bucket/cluster-a/backups/b%201/velero-backup.json", *input.CopySource)
+		assert.Regexp(t, `^velero.io%2Ftrashed-at=`, *input.Tagging)
+		assert.Equal(t, s3.TaggingDirectiveReplace, *input.TaggingDirective)
+		assert.Equal(t, "my-key", *input.SSEKMSKeyId)
+	}).Return(&s3.CopyObjectOutput{}, nil)
+	s.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("cluster-a/backups/b 1/velero-backup.json"),
+	}).Return(&s3.DeleteObjectOutput{}, nil)
+
+	assert.NoError(t, o.DeleteObject("bucket", "cluster-a/backups/b 1/velero-backup.json"))
+}
+
+func TestSoftDeleteObjectCopyFails(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(1024)}, nil)
+	s.On("CopyObjectWithContext", mock.Anything).Return(&s3.CopyObjectOutput{}, awserr.New("AccessDenied", "Access Denied", nil))
+
+	// the original must not be deleted if it couldn't be trashed
+	err := o.DeleteObject("bucket", "backups/b1/b1.tar.gz")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error moving object backups/b1/b1.tar.gz to trash")
+	s.AssertNotCalled(t, "DeleteObjectWithContext", mock.Anything)
+}
+
+func TestSoftDeleteMissingObject(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{}, awserr.New(notFoundCode, "Not Found", nil))
+
+	assert.NoError(t, o.DeleteObject("bucket", "backups/b1/b1.tar.gz"))
+	s.AssertNotCalled(t, "CopyObjectWithContext", mock.Anything)
+	s.AssertNotCalled(t, "DeleteObjectWithContext", mock.Anything)
+}
+
+func TestSoftDeleteTrashedObject(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	key := "plugins/aws/trash/20200501T123000Z/backups/b1/b1.tar.gz"
+	s.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String(key),
+	}).Return(&s3.DeleteObjectOutput{}, nil)
+
+	assert.NoError(t, o.DeleteObject("bucket", key))
+}
+
+func TestSoftDeleteLargeObject(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	size := int64(maxCopyObjectSize + 1)
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(size)}, nil)
+	s.On("CreateMultipartUploadWithContext", mock.Anything).Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil)
+
+	var ranges []string
+	s.On("UploadPartCopyWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*s3.UploadPartCopyInput)
+		ranges = append(ranges, *input.CopySourceRange)
+	}).Return(&s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: aws.String("etag")}}, nil)
+	s.On("CompleteMultipartUploadWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		input := args.Get(0).(*s3.CompleteMultipartUploadInput)
+		assert.Len(t, input.MultipartUpload.Parts, 11)
+	}).Return(&s3.CompleteMultipartUploadOutput{}, nil)
+	s.On("DeleteObjectWithContext", mock.Anything).Return(&s3.DeleteObjectOutput{}, nil)
+
+	require.NoError(t, o.DeleteObject("bucket", "backups/b1/b1.tar.gz"))
+
+	require.Len(t, ranges, 11)
+	assert.Equal(t, "bytes=0-536870911", ranges[0])
+	assert.Equal(t, "bytes=5368709120-5368709120", ranges[10])
+}
+
+func TestSoftDeleteLargeObjectPartFails(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, trash: trashOptions{enabled: true}}
+
+	s.On("HeadObjectWithContext", mock.Anything).Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(maxCopyObjectSize + 1)}, nil)
+	s.On("CreateMultipartUploadWithContext", mock.Anything).Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil)
+	s.On("UploadPartCopyWithContext", mock.Anything).Return(&s3.UploadPartCopyOutput{}, awserr.New("InternalError", "oops", nil)).Once()
+	s.On("AbortMultipartUploadWithContext", mock.Anything).Return(&s3.AbortMultipartUploadOutput{}, nil)
+
+	assert.Error(t, o.DeleteObject("bucket", "backups/b1/b1.tar.gz"))
+}
+
+func TestPurgeTrash(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, prefix: "cluster-a", trash: trashOptions{retention: 24 * time.Hour}}
+	now := time.Date(2020, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	s.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+		Bucket:    aws.String("bucket"),
+		Prefix:    aws.String("cluster-a/plugins/aws/trash/"),
+		Delimiter: aws.String("/"),
+	}, mock.Anything).Run(func(args mock.Arguments) {
+		fn := args.Get(1).(func(*s3.ListObjectsV2Output, bool) bool)
+		fn(&s3.ListObjectsV2Output{CommonPrefixes: []*s3.CommonPrefix{
+			{Prefix: aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/")},
+			{Prefix: aws.String("cluster-a/plugins/aws/trash/20200509T120000Z/")},
+			{Prefix: aws.String("cluster-a/plugins/aws/trash/not-a-time/")},
+		}}, true)
+	}).Return(nil)
+
+	s.On("ListObjectsV2PagesWithContext", &s3.ListObjectsV2Input{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/"),
+	}, mock.Anything).Run(func(args mock.Arguments) {
+		fn := args.Get(1).(func(*s3.ListObjectsV2Output, bool) bool)
+		fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{
+			{Key: aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/backups/b1/b1.tar.gz")},
+			{Key: aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/backups/b1/velero-backup.json")},
+		}}, true)
+	}).Return(nil)
+
+	s.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/backups/b1/b1.tar.gz"),
+	}).Return(&s3.DeleteObjectOutput{}, nil)
+	s.On("DeleteObjectWithContext", &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("cluster-a/plugins/aws/trash/20200501T000000Z/backups/b1/velero-backup.json"),
+	}).Return(&s3.DeleteObjectOutput{}, awserr.New("AccessDenied", "Access Denied", nil))
+
+	err := o.purgeTrash("bucket", now)
+	require.Error(t, err)
+	assert.Equal(t, "failed to purge 1 trashed objects or directories", err.Error())
+}
@@ -21,7 +21,9 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,9 +35,47 @@ import (
 )
 
 var (
-	errInvalidMethod = errors.New("v1 signer only handles HTTP GET")
+	errInvalidMethod = errors.New("v1 signer only handles HTTP GET, HEAD and PUT")
 )
 
+// v1Methods are the HTTP methods the V1 signer can presign.
+var v1Methods = map[string]bool{
+	http.MethodGet:  true,
+	http.MethodHead: true,
+	http.MethodPut:  true,
+}
+
+// s3SubResources are the query parameters that identify a sub-resource and
+// so must be included in the canonical resource that's signed by the V1 and
+// V2 signers, e.g. the upload ID and part number of a multipart upload part.
+var s3SubResources = map[string]bool{
+	"acl":                          true,
+	"cors":                         true,
+	"delete":                       true,
+	"lifecycle":                    true,
+	"location":                     true,
+	"logging":                      true,
+	"notification":                 true,
+	"ownershipControls":            true,
+	"partNumber":                   true,
+	"policy":                       true,
+	"requestPayment":               true,
+	"response-cache-control":       true,
+	"response-content-disposition": true,
+	"response-content-encoding":    true,
+	"response-content-language":    true,
+	"response-content-type":        true,
+	"response-expires":             true,
+	"tagging":                      true,
+	"torrent":                      true,
+	"uploadId":                     true,
+	"uploads":                      true,
+	"versionId":                    true,
+	"versioning":                   true,
+	"versions":                     true,
+	"website":                      true,
+}
+
 type signer struct {
 	// Values that must be populated from the request
 	request     *request.Request
@@ -44,9 +84,10 @@ type signer struct {
 	debug       aws.LogLevelType
 	logger      aws.Logger
 
-	query        url.Values
-	stringToSign string
-	signature    string
+	query         url.Values
+	signedHeaders http.Header
+	stringToSign  string
+	signature     string
 }
 
 // SignRequestHandler is a named request handler the SDK will use to sign
@@ -62,8 +103,7 @@ func signSDKRequest(req *request.Request) {
 		return
 	}
 
-	if req.HTTPRequest.Method != "GET" {
-		// The V1 signer only supports GET
+	if !v1Methods[req.HTTPRequest.Method] {
 		req.Error = errInvalidMethod
 		return
 	}
@@ -83,6 +123,7 @@ func signSDKRequest(req *request.Request) {
 	}
 
 	req.HTTPRequest.URL.RawQuery = v1.query.Encode()
+	req.SignedHeaderVals = v1.signedHeaders
 }
 
 func (v1 *signer) sign() error {
@@ -110,15 +151,24 @@ func (v1 *signer) sign() error {
 		path = "/"
 	}
 
-	duration := int64(v1.request.ExpireTime / time.Second)
-	expires := strconv.FormatInt(duration, 10)
+	// Expires is when the URL expires, in seconds since the epoch
+	expires := strconv.FormatInt(v1.time.Add(v1.request.ExpireTime).Unix(), 10)
+	// the content headers and any x-amz- headers are signed, so they
+	// must be sent with the request
+	v1.signedHeaders = http.Header{}
+	for _, name := range []string{"Content-Md5", "Content-Type"} {
+		if val := httpRequest.Header.Get(name); val != "" {
+			v1.signedHeaders.Set(name, val)
+		}
+	}
+
 	// build the canonical string for the v1 signature
 	v1.stringToSign = strings.Join([]string{
 		method,
-		"",
-		"",
+		v1.signedHeaders.Get("Content-Md5"),
+		v1.signedHeaders.Get("Content-Type"),
 		expires,
-		path,
+		canonicalAmzHeaders(httpRequest.Header, v1.signedHeaders) + canonicalResource(path, v1.query),
 	}, "\n")
 
 	hash := hmac.New(sha1.New, []byte(credentialsValue.SecretAccessKey))
@@ -134,6 +184,57 @@ func (v1 *signer) sign() error {
 	return nil
 }
 
+// canonicalAmzHeaders returns the x-amz- headers in header, lowercased and
+// sorted, one "name:value" line per header, and adds them to signed.
+func canonicalAmzHeaders(header, signed http.Header) string {
+	var names []string
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+
+	var canonical strings.Builder
+	for _, name := range names {
+		values := make([]string, 0, len(header[name]))
+		for _, val := range header[name] {
+			values = append(values, strings.TrimSpace(val))
+		}
+		canonical.WriteString(strings.ToLower(name) + ":" + strings.Join(values, ",") + "\n")
+		signed[name] = header[name]
+	}
+
+	return canonical.String()
+}
+
+// canonicalResource returns path with the sub-resources in query, sorted by
+// name, appended as a query string.
+func canonicalResource(path string, query url.Values) string {
+	var names []string
+	for name := range query {
+		if s3SubResources[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return path
+	}
+	sort.Strings(names)
+
+	subResources := make([]string, 0, len(names))
+	for _, name := range names {
+		// sub-resource values are signed as is, not URL-encoded
+		if val := query.Get(name); val != "" {
+			subResources = append(subResources, name+"="+val)
+		} else {
+			subResources = append(subResources, name)
+		}
+	}
+
+	return path + "?" + strings.Join(subResources, "&")
+}
+
 const logSignInfoMsg = `DEBUG: Request Signature:
 ---[ STRING TO SIGN ]--------------------------------
 %s
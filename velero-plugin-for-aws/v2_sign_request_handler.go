@@ -0,0 +1,206 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// v2DateFormat is the format of the Date header signed by the V2 signer.
+const v2DateFormat = "Mon, 02 Jan 2006 15:04:05 +0000"
+
+// v2SignRequestHandler signs S3 requests with AWS Signature Version 2, in the
+// Authorization header for requests that are sent and in the query string
+// for presigned URLs. See
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/RESTAuthentication.html.
+var v2SignRequestHandler = request.NamedHandler{
+	Name: "v2.SignRequestHandler", Fn: signV2SDKRequest,
+}
+
+type v2Signer struct {
+	request     *request.Request
+	time        time.Time
+	credentials *credentials.Credentials
+	debug       aws.LogLevelType
+	logger      aws.Logger
+
+	signedHeaders http.Header
+	stringToSign  string
+	signature     string
+}
+
+func signV2SDKRequest(req *request.Request) {
+	// If the request does not need to be signed ignore the signing of the
+	// request if the AnonymousCredentials object is used.
+	if req.Config.Credentials == credentials.AnonymousCredentials {
+		return
+	}
+
+	signTime := req.Time
+	if !req.LastSignedAt.IsZero() {
+		// a retry must be signed with the current time, or S3 rejects
+		// it once the original time is too far in the past
+		signTime = time.Now()
+	}
+
+	v2 := v2Signer{
+		request:     req,
+		time:        signTime,
+		credentials: req.Config.Credentials,
+		debug:       req.Config.LogLevel.Value(),
+		logger:      req.Config.Logger,
+	}
+
+	if req.Error = v2.sign(); req.Error != nil {
+		return
+	}
+
+	req.SignedHeaderVals = v2.signedHeaders
+	req.LastSignedAt = signTime
+}
+
+func (v2 *v2Signer) sign() error {
+	credentialsValue, err := v2.credentials.Get()
+	if err != nil {
+		return errors.Wrap(err, "error getting credentials")
+	}
+
+	httpRequest := v2.request.HTTPRequest
+	presign := v2.request.ExpireTime > 0
+	query := httpRequest.URL.Query()
+
+	// in case this is a retry, remove the previous signature
+	httpRequest.Header.Del("Authorization")
+	for _, name := range []string{"AWSAccessKeyId", "Expires", "Signature", "x-amz-security-token"} {
+		query.Del(name)
+	}
+
+	// a presigned URL carries its expiry time where a request that's sent
+	// immediately carries the time it was signed
+	var date string
+	if presign {
+		date = strconv.FormatInt(v2.time.Add(v2.request.ExpireTime).Unix(), 10)
+	} else {
+		httpRequest.Header.Del("X-Amz-Date")
+		date = v2.time.UTC().Format(v2DateFormat)
+		httpRequest.Header.Set("Date", date)
+	}
+
+	// the security token of temporary credentials is signed as an x-amz-
+	// header, but goes in the query string of a presigned URL
+	header := httpRequest.Header
+	if credentialsValue.SessionToken != "" {
+		if presign {
+			header = header.Clone()
+		}
+		header.Set("X-Amz-Security-Token", credentialsValue.SessionToken)
+	}
+
+	v2.signedHeaders = http.Header{}
+	for _, name := range []string{"Content-Md5", "Content-Type"} {
+		if val := httpRequest.Header.Get(name); val != "" {
+			v2.signedHeaders.Set(name, val)
+		}
+	}
+
+	v2.stringToSign = strings.Join([]string{
+		httpRequest.Method,
+		v2.signedHeaders.Get("Content-Md5"),
+		v2.signedHeaders.Get("Content-Type"),
+		date,
+		canonicalAmzHeaders(header, v2.signedHeaders) + canonicalResource(v2.canonicalPath(), query),
+	}, "\n")
+
+	hash := hmac.New(sha1.New, []byte(credentialsValue.SecretAccessKey))
+	hash.Write([]byte(v2.stringToSign))
+	v2.signature = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	if presign {
+		v2.signedHeaders.Del("X-Amz-Security-Token")
+		query.Set("AWSAccessKeyId", credentialsValue.AccessKeyID)
+		query.Set("Expires", date)
+		query.Set("Signature", v2.signature)
+		if credentialsValue.SessionToken != "" {
+			query.Set("x-amz-security-token", credentialsValue.SessionToken)
+		}
+	} else {
+		httpRequest.Header.Set("Authorization", fmt.Sprintf("AWS %s:%s", credentialsValue.AccessKeyID, v2.signature))
+	}
+	httpRequest.URL.RawQuery = query.Encode()
+
+	if v2.debug.Matches(aws.LogDebugWithSigning) {
+		v2.logSigningInfo()
+	}
+
+	return nil
+}
+
+// canonicalPath returns the request's path, prefixed with the bucket name if
+// the bucket is in the host name rather than the path, i.e. when using
+// virtual-hosted-style requests or a CNAME for the bucket. Path-style
+// requests already have the bucket in their path, even if the host name
+// happens to start with it, e.g. for bucket "minio" at minio.example.com.
+func (v2 *v2Signer) canonicalPath() string {
+	path := v2.request.HTTPRequest.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	if aws.BoolValue(v2.request.Config.S3ForcePathStyle) {
+		return path
+	}
+
+	var bucket string
+	if values, err := awsutil.ValuesAtPath(v2.request.Params, "Bucket"); err == nil && len(values) > 0 {
+		if b, ok := values[0].(*string); ok {
+			bucket = aws.StringValue(b)
+		}
+	}
+	if bucket == "" {
+		return path
+	}
+
+	host := v2.request.HTTPRequest.URL.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if host == bucket || strings.HasPrefix(host, bucket+".") {
+		return "/" + bucket + path
+	}
+
+	return path
+}
+
+func (v2 *v2Signer) logSigningInfo() {
+	msg := fmt.Sprintf(logSignInfoMsg, v2.stringToSign, v2.signature)
+	v2.logger.Log(msg)
+}
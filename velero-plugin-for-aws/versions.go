@@ -0,0 +1,136 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const deleteAllVersionsKey = "deleteAllVersions"
+
+// objectVersion is a version of an object in a versioned bucket.
+type objectVersion struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId"`
+	LastModified time.Time `json:"lastModified"`
+	Size         int64     `json:"size"`
+	// IsLatest is true for the current version of an object that hasn't
+	// been deleted.
+	IsLatest bool `json:"isLatest"`
+}
+
+// removeAllVersions permanently deletes every version and delete marker of
+// the object at key. On a bucket without versioning this deletes the object.
+func (o *ObjectStore) removeAllVersions(ctx aws.Context, bucket, key string) error {
+	var ids []*s3.ObjectIdentifier
+
+	err := o.s3.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		// the prefix also matches other keys that start with key
+		for _, version := range page.Versions {
+			if aws.StringValue(version.Key) == key {
+				ids = append(ids, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.StringValue(marker.Key) == key {
+				ids = append(ids, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error listing versions of object %s", key)
+	}
+
+	for start := 0; start < len(ids); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		failures, err := o.deleteBatch(ctx, bucket, ids[start:end])
+		if err != nil {
+			return errors.Wrapf(err, "error deleting versions of object %s", key)
+		}
+		if len(failures) > 0 {
+			return errors.Wrapf(&batchDeleteError{failures: failures}, "error deleting versions of object %s", key)
+		}
+	}
+
+	o.log.WithField("key", key).Debugf("Deleted %d versions of object", len(ids))
+
+	return nil
+}
+
+// getObjectVersion returns the content of a specific version of the object
+// at key, e.g. one that was deleted or overwritten. Velero has no way to
+// request a version, so this is run by the get-version command to recover
+// objects outside of Velero.
+func (o *ObjectStore) getObjectVersion(bucket, key, versionID string) (body io.ReadCloser, err error) {
+	ctx, span := startSpan("ObjectStore.getObjectVersion", bucketAttribute.String(bucket), keyAttribute.String(key))
+	defer func() { endSpan(span, err) }()
+
+	res, err := o.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting version %s of object %s", versionID, key)
+	}
+
+	return res.Body, nil
+}
+
+// listRecoverableVersions returns the versions of the objects under prefix,
+// e.g. "backups/<name>/", that can still be read, newest first for each key.
+// Delete markers aren't returned; a key whose latest version is a delete
+// marker has no version with IsLatest set. It's run by the list-versions
+// command.
+func (o *ObjectStore) listRecoverableVersions(bucket, prefix string) (versions []objectVersion, err error) {
+	ctx, span := startSpan("ObjectStore.listRecoverableVersions", bucketAttribute.String(bucket), keyAttribute.String(prefix))
+	defer func() { endSpan(span, err) }()
+
+	err = o.s3.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			versions = append(versions, objectVersion{
+				Key:          aws.StringValue(version.Key),
+				VersionID:    aws.StringValue(version.VersionId),
+				LastModified: aws.TimeValue(version.LastModified),
+				Size:         aws.Int64Value(version.Size),
+				IsLatest:     aws.BoolValue(version.IsLatest),
+			})
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing versions under %s", prefix)
+	}
+
+	return versions, nil
+}
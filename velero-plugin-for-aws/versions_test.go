@@ -0,0 +1,165 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func listVersionsPage(page *s3.ListObjectVersionsOutput) func(mock.Arguments) {
+	return func(args mock.Arguments) {
+		fn := args.Get(1).(func(*s3.ListObjectVersionsOutput, bool) bool)
+		fn(page, true)
+	}
+}
+
+func TestDeleteAllVersions(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, deleteAllVersions: true}
+
+	s.On("ListObjectVersionsPagesWithContext", &s3.ListObjectVersionsInput{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("backups/b1/b1.tar.gz"),
+	}, mock.Anything).Run(listVersionsPage(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("v2")},
+			{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("v1")},
+			{Key: aws.String("backups/b1/b1.tar.gz.bak"), VersionId: aws.String("other")},
+		},
+		DeleteMarkers: []*s3.DeleteMarkerEntry{
+			{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("marker")},
+		},
+	})).Return(nil)
+
+	s.On("DeleteObjectsWithContext", &s3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("v2")},
+				{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("v1")},
+				{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("marker")},
+			},
+			Quiet: aws.Bool(true),
+		},
+	}).Return(&s3.DeleteObjectsOutput{}, nil)
+
+	assert.NoError(t, o.DeleteObject("bucket", "backups/b1/b1.tar.gz"))
+}
+
+func TestDeleteAllVersionsBatches(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, deleteAllVersions: true}
+
+	var versions []*s3.ObjectVersion
+	for i := 0; i < maxDeleteObjects+1; i++ {
+		versions = append(versions, &s3.ObjectVersion{Key: aws.String("key"), VersionId: aws.String(fmt.Sprintf("v%d", i))})
+	}
+	s.On("ListObjectVersionsPagesWithContext", mock.Anything, mock.Anything).
+		Run(listVersionsPage(&s3.ListObjectVersionsOutput{Versions: versions})).
+		Return(nil)
+
+	var batches []int
+	s.On("DeleteObjectsWithContext", mock.Anything).Run(func(args mock.Arguments) {
+		batches = append(batches, len(args.Get(0).(*s3.DeleteObjectsInput).Delete.Objects))
+	}).Return(&s3.DeleteObjectsOutput{}, nil)
+
+	require.NoError(t, o.DeleteObject("bucket", "key"))
+	assert.Equal(t, []int{maxDeleteObjects, 1}, batches)
+}
+
+func TestDeleteAllVersionsPartialFailure(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s, deleteAllVersions: true}
+
+	s.On("ListObjectVersionsPagesWithContext", mock.Anything, mock.Anything).
+		Run(listVersionsPage(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			{Key: aws.String("key"), VersionId: aws.String("v1")},
+		}})).
+		Return(nil)
+	s.On("DeleteObjectsWithContext", mock.Anything).Return(&s3.DeleteObjectsOutput{
+		Errors: []*s3.Error{
+			{Key: aws.String("key"), VersionId: aws.String("v1"), Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")},
+		},
+	}, nil)
+
+	err := o.DeleteObject("bucket", "key")
+	require.Error(t, err)
+	assert.Equal(t, "error deleting versions of object key: failed to delete 1 objects: key (version v1): AccessDenied: Access Denied", err.Error())
+}
+
+func TestListRecoverableVersions(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+	modified := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	s.On("ListObjectVersionsPagesWithContext", &s3.ListObjectVersionsInput{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("backups/b1/"),
+	}, mock.Anything).Run(listVersionsPage(&s3.ListObjectVersionsOutput{
+		Versions: []*s3.ObjectVersion{
+			{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("v1"), LastModified: aws.Time(modified), Size: aws.Int64(10)},
+			{Key: aws.String("backups/b1/velero-backup.json"), VersionId: aws.String("v2"), LastModified: aws.Time(modified), Size: aws.Int64(5), IsLatest: aws.Bool(true)},
+		},
+		DeleteMarkers: []*s3.DeleteMarkerEntry{
+			{Key: aws.String("backups/b1/b1.tar.gz"), VersionId: aws.String("marker"), IsLatest: aws.Bool(true)},
+		},
+	})).Return(nil)
+
+	versions, err := o.listRecoverableVersions("bucket", "backups/b1/")
+	require.NoError(t, err)
+	assert.Equal(t, []objectVersion{
+		{Key: "backups/b1/b1.tar.gz", VersionID: "v1", LastModified: modified, Size: 10},
+		{Key: "backups/b1/velero-backup.json", VersionID: "v2", LastModified: modified, Size: 5, IsLatest: true},
+	}, versions)
+}
+
+func TestGetObjectVersion(t *testing.T) {
+	s := new(mockS3)
+	defer s.AssertExpectations(t)
+
+	o := &ObjectStore{log: newLogger(), s3: s}
+
+	s.On("GetObjectWithContext", &s3.GetObjectInput{
+		Bucket:    aws.String("bucket"),
+		Key:       aws.String("backups/b1/b1.tar.gz"),
+		VersionId: aws.String("v1"),
+	}).Return(&s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader("content"))}, nil)
+
+	body, err := o.getObjectVersion("bucket", "backups/b1/b1.tar.gz", "v1")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/pkg/errors"
@@ -44,8 +45,10 @@ const regionKey = "region"
 var iopsVolumeTypes = sets.NewString("io1")
 
 type VolumeSnapshotter struct {
-	log logrus.FieldLogger
-	ec2 *ec2.EC2
+	log    logrus.FieldLogger
+	region string
+	audit  *auditLogger
+	ec2    *ec2.EC2
 }
 
 // takes AWS session options to create a new session
@@ -58,6 +61,12 @@ func getSession(options session.Options) (*session.Session, error) {
 	if _, err := sess.Config.Credentials.Get(); err != nil {
 		return nil, errors.WithStack(err)
 	}
+
+	sess.Handlers.Validate.PushFrontNamed(awsRequestStartSpanHandler)
+	sess.Handlers.Complete.PushBackNamed(awsRequestEndSpanHandler)
+	sess.Handlers.Complete.PushBackNamed(awsRequestMetricsHandler)
+	sess.Handlers.Complete.PushBackNamed(awsUploadMetricsHandler)
+
 	return sess, nil
 }
 
@@ -66,7 +75,29 @@ func newVolumeSnapshotter(logger logrus.FieldLogger) *VolumeSnapshotter {
 }
 
 func (b *VolumeSnapshotter) Init(config map[string]string) error {
-	if err := veleroplugin.ValidateVolumeSnapshotterConfigKeys(config, regionKey, credentialProfileKey); err != nil {
+	if err := veleroplugin.ValidateVolumeSnapshotterConfigKeys(config,
+		regionKey,
+		credentialProfileKey,
+		connectTimeoutKey,
+		responseHeaderTimeoutKey,
+		socketTimeoutKey,
+		maxIdleConnsKey,
+		enableHTTP2Key,
+		maxRetriesKey,
+		minRetryDelayKey,
+		maxRetryDelayKey,
+		retryJitterKey,
+		apiRateLimitKey,
+		apiRateBurstKey,
+		metricsAddressKey,
+		tracingEndpointKey,
+		tracingInsecureKey,
+		auditLogPathKey,
+	); err != nil {
+		return err
+	}
+
+	if err := initTracing(config, b.log); err != nil {
 		return err
 	}
 
@@ -76,7 +107,25 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 		return errors.Errorf("missing %s in aws configuration", regionKey)
 	}
 
-	awsConfig := aws.NewConfig().WithRegion(region)
+	transport, err := parseTransportOptions(config)
+	if err != nil {
+		return err
+	}
+
+	retry, err := parseRetryOptions(config)
+	if err != nil {
+		return err
+	}
+
+	rateLimit, err := parseRateLimitOptions(config)
+	if err != nil {
+		return err
+	}
+
+	awsConfig := aws.NewConfig().
+		WithRegion(region).
+		WithHTTPClient(newHTTPClient(transport))
+	request.WithRetryer(awsConfig, newRetryer(retry, b.log))
 
 	sessionOptions := session.Options{Config: *awsConfig, Profile: credentialProfile}
 	sess, err := getSession(sessionOptions)
@@ -84,18 +133,34 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 		return err
 	}
 
+	if limiter := getRateLimiter(region+"/"+credentialProfile, rateLimit); limiter != nil {
+		sess.Handlers.Sign.PushFrontNamed(newRateLimitHandler(limiter))
+	}
+
+	if b.audit, err = newAuditLogger(config[auditLogPathKey], sess, true, region, b.log); err != nil {
+		return err
+	}
+
+	b.region = region
 	b.ec2 = ec2.New(sess)
 
 	return nil
 }
 
 func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, volumeAZ string, iops *int64) (volumeID string, err error) {
+	ctx, span := startSpan("VolumeSnapshotter.CreateVolumeFromSnapshot", snapshotIDAttribute.String(snapshotID))
+	defer func() {
+		b.audit.record(auditEntry{Operation: "VolumeSnapshotter.CreateVolumeFromSnapshot", SnapshotID: snapshotID, VolumeID: volumeID}, err)
+		span.SetAttributes(volumeIDAttribute.String(volumeID))
+		endSpan(span, err)
+	}()
+
 	// describe the snapshot so we can apply its tags to the volume
 	snapReq := &ec2.DescribeSnapshotsInput{
 		SnapshotIds: []*string{&snapshotID},
 	}
 
-	snapRes, err := b.ec2.DescribeSnapshots(snapReq)
+	snapRes, err := b.ec2.DescribeSnapshotsWithContext(ctx, snapReq)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -123,7 +188,7 @@ func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, vol
 		req.Iops = iops
 	}
 
-	res, err := b.ec2.CreateVolume(req)
+	res, err := b.ec2.CreateVolumeWithContext(ctx, req)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -131,17 +196,15 @@ func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, vol
 	return *res.VolumeId, nil
 }
 
-func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (string, *int64, error) {
-	volumeInfo, err := b.describeVolume(volumeID)
+func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (volumeType string, iops *int64, err error) {
+	ctx, span := startSpan("VolumeSnapshotter.GetVolumeInfo", volumeIDAttribute.String(volumeID))
+	defer func() { endSpan(span, err) }()
+
+	volumeInfo, err := b.describeVolume(ctx, volumeID)
 	if err != nil {
 		return "", nil, err
 	}
 
-	var (
-		volumeType string
-		iops       *int64
-	)
-
 	if volumeInfo.VolumeType != nil {
 		volumeType = *volumeInfo.VolumeType
 	}
@@ -153,12 +216,12 @@ func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (string, *i
 	return volumeType, iops, nil
 }
 
-func (b *VolumeSnapshotter) describeVolume(volumeID string) (*ec2.Volume, error) {
+func (b *VolumeSnapshotter) describeVolume(ctx aws.Context, volumeID string) (*ec2.Volume, error) {
 	req := &ec2.DescribeVolumesInput{
 		VolumeIds: []*string{&volumeID},
 	}
 
-	res, err := b.ec2.DescribeVolumes(req)
+	res, err := b.ec2.DescribeVolumesWithContext(ctx, req)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -169,14 +232,21 @@ func (b *VolumeSnapshotter) describeVolume(volumeID string) (*ec2.Volume, error)
 	return res.Volumes[0], nil
 }
 
-func (b *VolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[string]string) (string, error) {
+func (b *VolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[string]string) (snapshotID string, err error) {
+	ctx, span := startSpan("VolumeSnapshotter.CreateSnapshot", volumeIDAttribute.String(volumeID))
+	defer func() {
+		b.audit.record(auditEntry{Operation: "VolumeSnapshotter.CreateSnapshot", VolumeID: volumeID, SnapshotID: snapshotID}, err)
+		span.SetAttributes(snapshotIDAttribute.String(snapshotID))
+		endSpan(span, err)
+	}()
+
 	// describe the volume so we can copy its tags to the snapshot
-	volumeInfo, err := b.describeVolume(volumeID)
+	volumeInfo, err := b.describeVolume(ctx, volumeID)
 	if err != nil {
 		return "", err
 	}
 
-	res, err := b.ec2.CreateSnapshot(&ec2.CreateSnapshotInput{
+	res, err := b.ec2.CreateSnapshotWithContext(ctx, &ec2.CreateSnapshotInput{
 		VolumeId: &volumeID,
 		TagSpecifications: []*ec2.TagSpecification{
 			{
@@ -241,19 +311,27 @@ func ec2Tag(key, val string) *ec2.Tag {
 	return &ec2.Tag{Key: &key, Value: &val}
 }
 
-func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) error {
+func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) (err error) {
+	ctx, span := startSpan("VolumeSnapshotter.DeleteSnapshot", snapshotIDAttribute.String(snapshotID))
+	defer func() { endSpan(span, err) }()
+
 	req := &ec2.DeleteSnapshotInput{
 		SnapshotId: &snapshotID,
 	}
 
-	_, err := b.ec2.DeleteSnapshot(req)
+	_, err = b.ec2.DeleteSnapshotWithContext(ctx, req)
 
 	// if it's a NotFound error, we don't need to return an error
-	// since the snapshot is not there.
+	// since the snapshot is not there, but the audit log still
+	// records that nothing was deleted.
 	// see https://docs.aws.amazon.com/AWSEC2/latest/APIReference/errors-overview.html
 	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidSnapshot.NotFound" {
+		b.log.WithField("snapshotID", snapshotID).Info("Snapshot to delete was not found")
+		b.audit.record(auditEntry{Operation: "VolumeSnapshotter.DeleteSnapshot", SnapshotID: snapshotID, Outcome: auditOutcomeNotFound}, nil)
 		return nil
 	}
+
+	b.audit.record(auditEntry{Operation: "VolumeSnapshotter.DeleteSnapshot", SnapshotID: snapshotID}, err)
 	if err != nil {
 		return errors.WithStack(err)
 	}